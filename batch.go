@@ -0,0 +1,167 @@
+package namecheap
+
+import (
+	"context"
+
+	"github.com/libdns/libdns"
+
+	"github.com/libdns/namecheap/internal/namecheap"
+)
+
+// Tx stages changes to the records of a zone. The changes are written
+// together when the Batch they belong to commits.
+type Tx struct {
+	changes []txChange
+}
+
+type txChangeKind int
+
+const (
+	txAppend txChangeKind = iota
+	txSet
+	txDelete
+)
+
+type txChange struct {
+	kind    txChangeKind
+	records []libdns.Record
+}
+
+// Append stages adding the records to the zone.
+func (tx *Tx) Append(records ...libdns.Record) {
+	tx.changes = append(tx.changes, txChange{kind: txAppend, records: records})
+}
+
+// Set stages updating the records with the same IDs. Records without an ID
+// or whose ID doesn't exist are added.
+func (tx *Tx) Set(records ...libdns.Record) {
+	tx.changes = append(tx.changes, txChange{kind: txSet, records: records})
+}
+
+// Delete stages removing the records like DeleteRecords. Records with an ID
+// are matched by ID and records without one by name, type and value. Records
+// that don't exist are ignored.
+func (tx *Tx) Delete(records ...libdns.Record) {
+	tx.changes = append(tx.changes, txChange{kind: txDelete, records: records})
+}
+
+// Batch calls fn to stage changes to the zone's records and then commits them
+// all with a single getHosts and a single setHosts regardless of how many
+// changes were staged. This saves requests compared to calling AppendRecords,
+// SetRecords and DeleteRecords separately since each of them reads and writes
+// the whole zone. With OptimisticLocking the changes are applied again if the
+// zone changed before it could be written.
+//
+// Either all of the changes are written or none of them are. If fn returns an
+// error, nothing is written. If writing fails, the staged changes are dropped
+// and the zone is left as it was. Nothing is written if the changes leave the
+// zone as it was.
+func (p *Provider) Batch(ctx context.Context, zone string, fn func(tx *Tx) error) error {
+	if p.ReadOnly {
+		return ErrReadOnly
+	}
+
+	tx := &Tx{}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if len(tx.changes) == 0 {
+		return nil
+	}
+
+	client, err := p.getClient()
+	if err != nil {
+		return err
+	}
+
+	unlock := p.lockZone(zone)
+	defer unlock()
+
+	// The changes are converted once since they may be applied again if the
+	// zone changes before it's written.
+	changeHosts := make([][]namecheap.HostRecord, len(tx.changes))
+	for i, change := range tx.changes {
+		if change.kind == txDelete {
+			changeHosts[i], err = toDeleteHostRecords(zone, change.records)
+		} else {
+			changeHosts[i], err = p.toHostRecords(zone, p.beforeWrite(change.records))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = client.ModifyHosts(ctx, zone, func(hosts []namecheap.HostRecord) ([]namecheap.HostRecord, bool, error) {
+		var changed bool
+		for i, change := range tx.changes {
+			var changedHosts bool
+			hosts, changedHosts = applyTxChange(hosts, change.kind, changeHosts[i])
+			changed = changed || changedHosts
+		}
+		return hosts, changed, nil
+	})
+	return err
+}
+
+// applyTxChange applies a staged change to hosts and reports whether the
+// hosts changed. Hosts are matched by HostID like SetHosts and DeleteHosts,
+// and hosts to delete without an ID by value like DeleteHosts.
+func applyTxChange(hosts []namecheap.HostRecord, kind txChangeKind, changes []namecheap.HostRecord) ([]namecheap.HostRecord, bool) {
+	switch kind {
+	case txAppend:
+		return append(hosts, changes...), len(changes) > 0
+	case txSet:
+		var changed bool
+		for _, change := range changes {
+			i := indexOfHost(hosts, change.HostID)
+			switch {
+			case i < 0:
+				hosts = append(hosts, change)
+				changed = true
+			case hosts[i] != change:
+				hosts[i] = change
+				changed = true
+			}
+		}
+		return hosts, changed
+	case txDelete:
+		var changed bool
+		for _, change := range changes {
+			if change.HostID != "" {
+				if i := indexOfHost(hosts, change.HostID); i >= 0 {
+					hosts = append(hosts[:i], hosts[i+1:]...)
+					changed = true
+				}
+				continue
+			}
+
+			// Every host with the same value is removed.
+			remaining := hosts[:0]
+			for _, host := range hosts {
+				if namecheap.HostKey(host) == namecheap.HostKey(change) {
+					changed = true
+					continue
+				}
+				remaining = append(remaining, host)
+			}
+			hosts = remaining
+		}
+		return hosts, changed
+	}
+	return hosts, false
+}
+
+// indexOfHost returns the index of the host with the ID or -1 if there is
+// none. Hosts without an ID never match.
+func indexOfHost(hosts []namecheap.HostRecord, id string) int {
+	if id == "" {
+		return -1
+	}
+	for i, host := range hosts {
+		if host.HostID == id {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,104 @@
+package namecheap
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+// RecordBuilder builds a libdns record for namecheap. Records are named @ for
+// the apex, use namecheap's default TTL of 30 minutes and have fully
+// qualified hostname values, the same as records returned by GetRecords, so
+// built records compare equal to the records read back.
+type RecordBuilder struct {
+	record libdns.Record
+	err    error
+}
+
+func newRecord(recordType, name, value string) *RecordBuilder {
+	if name == "" {
+		name = "@"
+	}
+	return &RecordBuilder{record: libdns.Record{
+		Type:  recordType,
+		Name:  name,
+		Value: value,
+		TTL:   TTLFromSeconds(defaultTTL),
+	}}
+}
+
+// NewA starts an A record pointing name at an IPv4 address.
+func NewA(name, ip string) *RecordBuilder {
+	return newRecord("A", name, ip)
+}
+
+// NewAAAA starts an AAAA record pointing name at an IPv6 address.
+func NewAAAA(name, ip string) *RecordBuilder {
+	return newRecord("AAAA", name, ip)
+}
+
+// NewCNAME starts a CNAME record making name an alias of target.
+func NewCNAME(name, target string) *RecordBuilder {
+	return newRecord("CNAME", name, fromNamecheapHostname(target))
+}
+
+// NewALIAS starts an ALIAS record pointing name at the addresses of target.
+// Unlike a CNAME it can be used at the apex alongside other records.
+func NewALIAS(name, target string) *RecordBuilder {
+	return newRecord("ALIAS", name, fromNamecheapHostname(target))
+}
+
+// NewTXT starts a TXT record holding text.
+func NewTXT(name, text string) *RecordBuilder {
+	return newRecord("TXT", name, text)
+}
+
+// NewNS starts an NS record delegating name to the nameserver host.
+func NewNS(name, host string) *RecordBuilder {
+	return newRecord("NS", name, fromNamecheapHostname(host))
+}
+
+// NewMX starts an MX record delivering mail for name to host.
+func NewMX(name string, preference int, host string) *RecordBuilder {
+	b := newRecord("MX", name, fromNamecheapHostname(host))
+	b.record.Priority = preference
+	return b
+}
+
+// NewSRV starts an SRV record for the service at name e.g. _sip._tcp
+// provided by target on port.
+func NewSRV(name string, priority, weight, port int, target string) *RecordBuilder {
+	b := newRecord("SRV", name, fmt.Sprintf("%d %d %s", weight, port, fromNamecheapHostname(target)))
+	b.record.Priority = priority
+	return b
+}
+
+// NewCAA starts a CAA record e.g. NewCAA("@", 0, "issue", "letsencrypt.org").
+func NewCAA(name string, flags int, tag, value string) *RecordBuilder {
+	return newRecord("CAA", name, normalizeCAAValue(strconv.Itoa(flags)+" "+tag+" "+value))
+}
+
+// WithTTL sets the TTL of the record. It must be within namecheap's range of
+// 60 to 60000 seconds.
+func (b *RecordBuilder) WithTTL(ttl time.Duration) *RecordBuilder {
+	if seconds := TTLSeconds(ttl); seconds < minTTL || seconds > maxTTL {
+		b.err = fmt.Errorf("TTL of %s record %q must be between %d and %d seconds. Got: %d", b.record.Type, b.record.Name, minTTL, maxTTL, seconds)
+	}
+	b.record.TTL = ttl
+	return b
+}
+
+// Record returns the built record or why namecheap can't store it.
+func (b *RecordBuilder) Record() (libdns.Record, error) {
+	if b.err != nil {
+		return libdns.Record{}, b.err
+	}
+
+	if err := ValidateRecords([]libdns.Record{b.record}); err != nil {
+		return libdns.Record{}, err
+	}
+
+	return b.record, nil
+}
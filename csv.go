@@ -0,0 +1,123 @@
+package namecheap
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/libdns/libdns"
+
+	"github.com/libdns/namecheap/internal/namecheap"
+)
+
+// The column headers of namecheap's CSV export, lower cased, and the
+// alternative names they're known by.
+var csvColumns = map[string]string{
+	"type":        "type",
+	"record type": "type",
+	"host":        "host",
+	"name":        "host",
+	"value":       "value",
+	"address":     "value",
+	"ttl":         "ttl",
+	"priority":    "priority",
+	"mx pref":     "priority",
+	"mxpref":      "priority",
+}
+
+// RecordsFromNamecheapCSV reads records exported from namecheap's dashboard
+// as CSV, e.g. to import them into another account with ReplaceZone.
+// The first row names the columns. The Type, Host and Value columns are
+// required and the TTL and Priority columns are optional. A TTL of Automatic
+// or an empty TTL leaves the TTL unset so namecheap uses its default. Values
+// are read the way getHosts returns them so hostnames are made fully
+// qualified. TTLs outside of namecheap's range are adjusted to be within it
+// and reported through the provider's WarningHook and Logger. No requests are
+// made so a zero Provider can be used.
+func (p *Provider) RecordsFromNamecheapCSV(r io.Reader) ([]libdns.Record, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("CSV is empty. Expected a header row")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CSV header. Err: %s", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		if column, ok := csvColumns[strings.ToLower(strings.TrimSpace(name))]; ok {
+			columns[column] = i
+		}
+	}
+	for _, required := range []string{"type", "host", "value"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header: %s has no %s column", strings.Join(header, ","), required)
+		}
+	}
+
+	// Reading by column lets rows omit trailing optional fields.
+	reader.FieldsPerRecord = -1
+
+	var records []libdns.Record
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CSV. Err: %s", err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		field := func(column string) string {
+			i, ok := columns[column]
+			if !ok || i >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[i])
+		}
+
+		host := namecheap.HostRecord{
+			RecordType: namecheap.RecordType(strings.ToUpper(field("type"))),
+			Name:       field("host"),
+			Address:    field("value"),
+			MXPref:     field("priority"),
+		}
+		if host.RecordType == "" {
+			return nil, fmt.Errorf("CSV line: %d has no record type", line)
+		}
+		if host.Name == "" {
+			host.Name = "@"
+		}
+
+		if ttl := field("ttl"); ttl != "" && !strings.EqualFold(ttl, "automatic") {
+			seconds, err := strconv.Atoi(ttl)
+			if err != nil {
+				return nil, fmt.Errorf("CSV line: %d has an invalid TTL: %s", line, ttl)
+			}
+			host.TTL = clampTTL(seconds)
+			if int(host.TTL) != seconds {
+				p.warn(fmt.Sprintf("TTL of %s record %q on CSV line %d adjusted from %d to %d seconds to be within namecheap's range of %d to %d seconds",
+					host.RecordType, host.Name, line, seconds, host.TTL, minTTL, maxTTL),
+					slog.Int("line", line), slog.String("name", host.Name), slog.Int("ttl", seconds))
+			}
+		}
+
+		if host.MXPref != "" {
+			if _, err := strconv.Atoi(host.MXPref); err != nil {
+				return nil, fmt.Errorf("CSV line: %d has an invalid priority: %s", line, host.MXPref)
+			}
+		}
+
+		records = append(records, parseFromHostRecord(host))
+	}
+
+	return records, nil
+}
@@ -0,0 +1,116 @@
+package namecheap
+
+import (
+	"context"
+	"strings"
+
+	"github.com/libdns/libdns"
+
+	"github.com/libdns/namecheap/internal/namecheap"
+)
+
+// DetectDrift compares the records in the zone with the desired records and
+// reports how the zone differs from them without modifying it. added holds
+// the records in the zone that aren't desired and removed holds the desired
+// records missing from the zone. When the zone and the desired records both
+// have a record with the same name and type that differs in its value, TTL or
+// priority, changed holds the record as it is in the zone.
+//
+// Records are compared the way namecheap stores them so that e.g. a desired
+// record without a TTL matches one with namecheap's default TTL. Hosts that
+// libdns records can't represent, such as URL redirects, are ignored.
+func (p *Provider) DetectDrift(ctx context.Context, zone string, desired []libdns.Record) (added, removed, changed []libdns.Record, err error) {
+	desired = p.beforeWrite(desired)
+	desiredHosts, err := p.toHostRecords(zone, desired)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	client, err := p.getClient()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	hosts, err := client.GetHosts(ctx, zone)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var live []namecheap.HostRecord
+	for _, host := range hosts {
+		if modeledTypes[host.RecordType] {
+			live = append(live, host)
+		}
+	}
+
+	// Pair up identical records first so that only the differences remain.
+	matched := make([]bool, len(live))
+	var unmatched []int
+	for i, host := range desiredHosts {
+		if j := indexOfUnmatched(live, matched, host, zone, sameHost); j >= 0 {
+			matched[j] = true
+		} else {
+			unmatched = append(unmatched, i)
+		}
+	}
+
+	// What's left with the same name and type on both sides was changed.
+	for _, i := range unmatched {
+		if j := indexOfUnmatched(live, matched, desiredHosts[i], zone, sameHostName); j >= 0 {
+			matched[j] = true
+			changed = append(changed, parseFromHostRecord(live[j]))
+		} else {
+			removed = append(removed, desired[i])
+		}
+	}
+
+	for j, host := range live {
+		if !matched[j] {
+			added = append(added, parseFromHostRecord(host))
+		}
+	}
+
+	return p.withNameFormat(added, zone), removed, p.withNameFormat(changed, zone), nil
+}
+
+// indexOfUnmatched returns the index of the first host that isn't matched yet
+// and is the same as host according to same, or -1 if there is none.
+func indexOfUnmatched(hosts []namecheap.HostRecord, matched []bool, host namecheap.HostRecord, zone string, same func(a, b namecheap.HostRecord, zone string) bool) int {
+	for i := range hosts {
+		if !matched[i] && same(hosts[i], host, zone) {
+			return i
+		}
+	}
+	return -1
+}
+
+// sameHostName reports whether the hosts have the same name and type.
+func sameHostName(a, b namecheap.HostRecord, zone string) bool {
+	return a.RecordType == b.RecordType && relativeHostName(a.Name, zone) == relativeHostName(b.Name, zone)
+}
+
+// sameHost reports whether the hosts are the same record as namecheap
+// serves it.
+func sameHost(a, b namecheap.HostRecord, zone string) bool {
+	if !sameHostName(a, b, zone) || ttlOrDefault(a.TTL) != ttlOrDefault(b.TTL) {
+		return false
+	}
+
+	// namecheap returns an MXPref for every host but only MX and SRV use it.
+	if (a.RecordType == namecheap.MX || a.RecordType == namecheap.SRV) && a.MXPref != b.MXPref {
+		return false
+	}
+
+	if hostnameValueTypes[a.RecordType] {
+		return strings.EqualFold(a.Address, b.Address)
+	}
+	return a.Address == b.Address
+}
+
+// ttlOrDefault returns the TTL namecheap uses for a host with ttl.
+func ttlOrDefault(ttl uint16) uint16 {
+	if ttl == 0 {
+		return defaultTTL
+	}
+	return ttl
+}
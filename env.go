@@ -0,0 +1,52 @@
+package namecheap
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// The environment variables read by NewProviderFromEnv.
+const (
+	envAPIKey      = "NAMECHEAP_API_KEY"
+	envAPIUser     = "NAMECHEAP_API_USER"
+	envClientIP    = "NAMECHEAP_CLIENT_IP"
+	envAPIEndpoint = "NAMECHEAP_API_ENDPOINT"
+)
+
+// NewProviderFromEnv creates a provider from the environment variables:
+//
+//	NAMECHEAP_API_KEY      the API key. Required.
+//	NAMECHEAP_API_USER     the API user. Required.
+//	NAMECHEAP_CLIENT_IP    the whitelisted client IP. Discovered if not set.
+//	NAMECHEAP_API_ENDPOINT the API endpoint. Production if not set.
+//
+// Variables set to an empty string are treated as not set. The error lists
+// every missing required variable.
+func NewProviderFromEnv() (*Provider, error) {
+	p := &Provider{
+		APIKey:      os.Getenv(envAPIKey),
+		User:        os.Getenv(envAPIUser),
+		ClientIP:    os.Getenv(envClientIP),
+		APIEndpoint: os.Getenv(envAPIEndpoint),
+	}
+
+	var missing []string
+	if p.APIKey == "" {
+		missing = append(missing, envAPIKey)
+	}
+	if p.User == "" {
+		missing = append(missing, envAPIUser)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required environment variables: %s", strings.Join(missing, ", "))
+	}
+
+	// Parsed like the DSN's client_ip so both accept the same addresses.
+	if _, err := netip.ParseAddr(p.ClientIP); p.ClientIP != "" && err != nil {
+		return nil, fmt.Errorf("%s: %s is not a valid IP address", envClientIP, p.ClientIP)
+	}
+
+	return p, nil
+}
@@ -0,0 +1,26 @@
+package namecheap
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/libdns/namecheap/internal/namecheap"
+)
+
+// CountClients counts the clients constructed by providers until the test ends.
+func CountClients(t *testing.T) *int32 {
+	var count int32
+	original := newClient
+	newClient = func(apiKey, apiUser string, opts ...namecheap.ClientOption) (*namecheap.Client, error) {
+		atomic.AddInt32(&count, 1)
+		return original(apiKey, apiUser, opts...)
+	}
+	t.Cleanup(func() { newClient = original })
+
+	return &count
+}
+
+// Endpoint returns the API endpoint the provider sends requests to.
+func (p *Provider) Endpoint() (string, error) {
+	return p.endpoint()
+}
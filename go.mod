@@ -1,9 +1,10 @@
 module github.com/libdns/namecheap
 
-go 1.16
+go 1.21
 
 require (
 	github.com/google/go-cmp v0.5.6
 	github.com/libdns/libdns v0.2.1
-	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 )
+
+require golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
@@ -0,0 +1,93 @@
+package namecheap
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// HostsCache holds the hosts of domains for a limited time so that
+// read-modify-write operations can avoid fetching the hosts again.
+// It is safe for concurrent use and can be shared between clients.
+type HostsCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]hostsCacheEntry
+
+	// generations counts the invalidations of each domain so hosts fetched
+	// before a write can't fill the cache after it.
+	generations map[string]uint64
+}
+
+type hostsCacheEntry struct {
+	hosts   []HostRecord
+	expires time.Time
+}
+
+// NewHostsCache creates a cache that holds hosts for ttl.
+func NewHostsCache(ttl time.Duration) *HostsCache {
+	return &HostsCache{
+		ttl:         ttl,
+		entries:     make(map[string]hostsCacheEntry),
+		generations: make(map[string]uint64),
+	}
+}
+
+// cacheKey normalizes the domain so example.com and Example.com. share an entry.
+func cacheKey(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(domain, "."))
+}
+
+// get returns a copy of the cached hosts for domain if they haven't expired.
+// HostRecord holds no references so copying the slice is a deep copy and
+// callers can't modify the cached hosts.
+func (c *HostsCache) get(domain string) ([]HostRecord, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, found := c.entries[cacheKey(domain)]
+	if !found || time.Now().After(entry.expires) {
+		return nil, false
+	}
+
+	return append([]HostRecord(nil), entry.hosts...), true
+}
+
+// generation returns the current generation of domain. Pass it to set to
+// cache hosts fetched after this call.
+func (c *HostsCache) generation(domain string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generations[cacheKey(domain)]
+}
+
+// set caches a copy of hosts for domain unless domain was invalidated since
+// generation was returned, in which case the hosts may be from before a
+// write and are dropped.
+func (c *HostsCache) set(domain string, hosts []HostRecord, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := cacheKey(domain)
+	if c.generations[key] != generation {
+		return
+	}
+
+	c.entries[key] = hostsCacheEntry{
+		hosts:   append([]HostRecord(nil), hosts...),
+		expires: time.Now().Add(c.ttl),
+	}
+}
+
+// invalidate removes the cached hosts for domain and keeps fetches already
+// in flight from caching theirs.
+func (c *HostsCache) invalidate(domain string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := cacheKey(domain)
+	delete(c.entries, key)
+	c.generations[key]++
+}
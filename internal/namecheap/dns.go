@@ -0,0 +1,98 @@
+package namecheap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNotUsingNamecheapDNS is returned when writing the hosts of a domain that
+// doesn't use namecheap's nameservers and WithRequireNamecheapDNS is used.
+// namecheap accepts the hosts but doesn't serve them.
+var ErrNotUsingNamecheapDNS = errors.New("domain is not using namecheap DNS")
+
+// WithRequireNamecheapDNS makes writes fail with ErrNotUsingNamecheapDNS when
+// getHosts reported that the domain doesn't use namecheap's nameservers.
+// Without it such writes are reported through WithWarnings.
+func WithRequireNamecheapDNS() ClientOption {
+	return func(c *Client) error {
+		c.requireNamecheapDNS = true
+		return nil
+	}
+}
+
+// setUsingOurDNS records whether getHosts reported that domain uses
+// namecheap's nameservers.
+func (c *Client) setUsingOurDNS(domain string, usingOurDNS bool) {
+	c.dnsMu.Lock()
+	defer c.dnsMu.Unlock()
+
+	if c.usingOurDNS == nil {
+		c.usingOurDNS = make(map[string]bool)
+	}
+	c.usingOurDNS[cacheKey(domain)] = usingOurDNS
+}
+
+// checkUsingOurDNS fails or warns before writing the hosts of a domain that
+// was last reported not to use namecheap's nameservers. Domains whose hosts
+// haven't been fetched aren't checked.
+func (c *Client) checkUsingOurDNS(domain string) error {
+	c.dnsMu.Lock()
+	usingOurDNS, found := c.usingOurDNS[cacheKey(domain)]
+	c.dnsMu.Unlock()
+
+	if !found || usingOurDNS {
+		return nil
+	}
+
+	if c.requireNamecheapDNS {
+		return fmt.Errorf("domain: %s uses other nameservers so its records won't resolve. Switch it to namecheap's default nameservers first. Err: %w", domain, ErrNotUsingNamecheapDNS)
+	}
+
+	if c.warn != nil {
+		c.warn(fmt.Sprintf("domain: %s uses other nameservers so the records written to it won't resolve until it's switched to namecheap's default nameservers", domain))
+	}
+
+	return nil
+}
+
+// setZoneEmailType records the EmailType getHosts reported for domain.
+func (c *Client) setZoneEmailType(domain, emailType string) {
+	c.dnsMu.Lock()
+	defer c.dnsMu.Unlock()
+
+	if c.zoneEmailTypes == nil {
+		c.zoneEmailTypes = make(map[string]string)
+	}
+	c.zoneEmailTypes[cacheKey(domain)] = strings.ToUpper(emailType)
+}
+
+// HasEmailType reports whether the EmailType of domain is known from getHosts
+// so writing its hosts can keep it.
+func (c *Client) HasEmailType(domain string) bool {
+	c.dnsMu.Lock()
+	defer c.dnsMu.Unlock()
+
+	_, found := c.zoneEmailTypes[cacheKey(domain)]
+	return found
+}
+
+// preservedEmailType returns the EmailType getHosts last reported for domain
+// so writing hosts keeps it. MX and MXE are only kept while there are hosts
+// of that type since they describe the hosts rather than a mail service.
+func (c *Client) preservedEmailType(domain string, hostTypes map[RecordType]bool) string {
+	c.dnsMu.Lock()
+	emailType := c.zoneEmailTypes[cacheKey(domain)]
+	c.dnsMu.Unlock()
+
+	switch emailType {
+	case "MX", "MXE":
+		if !hostTypes[RecordType(emailType)] {
+			return ""
+		}
+	}
+	if !emailTypes[emailType] {
+		return ""
+	}
+	return emailType
+}
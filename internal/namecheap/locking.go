@@ -0,0 +1,88 @@
+package namecheap
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// The most times a read-modify-write is attempted with optimistic locking.
+const maxOptimisticLockingAttempts = 3
+
+// ErrConcurrentModification is returned with optimistic locking when the
+// hosts of a domain kept changing while they were being modified.
+var ErrConcurrentModification = errors.New("hosts were modified concurrently")
+
+// WithOptimisticLocking checks that the hosts of a domain haven't changed
+// since they were read right before writing them. If they have, the change is
+// made again on top of the new hosts. Since setHosts replaces all the hosts,
+// this keeps concurrent writers from discarding each other's changes. namecheap
+// can't make the check and the write atomic so a small window remains. Each
+// write costs an extra getHosts request.
+func WithOptimisticLocking() ClientOption {
+	return func(c *Client) error {
+		c.optimisticLocking = true
+		return nil
+	}
+}
+
+// ModifyHosts fetches the hosts of the domain, passes a copy of them to modify
+// and writes the hosts it returns if it reports a change. If it doesn't, the
+// hosts it returns are returned without writing them. With optimistic locking
+// modify is called again with the new hosts if they changed before they could
+// be written, so it shouldn't keep state from earlier calls.
+func (c *Client) ModifyHosts(ctx context.Context, domain string, modify func(existingHosts []HostRecord) ([]HostRecord, bool, error)) ([]HostRecord, error) {
+	ctx, cancel := c.withBudget(ctx)
+	defer cancel()
+
+	return c.readModifyWrite(ctx, domain, modify)
+}
+
+// readModifyWrite fetches the hosts of the domain, passes a copy of them to
+// modify and writes the hosts it returns if it reports a change. If it
+// doesn't, the hosts it returns are returned without writing them.
+func (c *Client) readModifyWrite(ctx context.Context, domain string, modify func(existingHosts []HostRecord) ([]HostRecord, bool, error)) ([]HostRecord, error) {
+	existingHosts, err := c.GetHosts(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		hosts, changed, err := modify(append([]HostRecord(nil), existingHosts...))
+		if err != nil || !changed {
+			return hosts, err
+		}
+
+		if !c.optimisticLocking {
+			return c.setHosts(ctx, domain, hosts)
+		}
+
+		// Bypass the cache since it can't know about changes made elsewhere.
+		currentHosts, err := c.fetchHosts(ctx, domain)
+		if err != nil {
+			return nil, err
+		}
+
+		if fingerprintHosts(currentHosts) == fingerprintHosts(existingHosts) {
+			return c.setHosts(ctx, domain, hosts)
+		}
+
+		if attempt >= maxOptimisticLockingAttempts {
+			return nil, fmt.Errorf("hosts of domain: %s changed during %d attempts to modify them. Err: %w", domain, attempt, ErrConcurrentModification)
+		}
+
+		existingHosts = currentHosts
+	}
+}
+
+// fingerprintHosts returns a hash of hosts that changes whenever any of them
+// is added, removed or modified.
+func fingerprintHosts(hosts []HostRecord) string {
+	h := sha256.New()
+	for _, host := range hosts {
+		fmt.Fprintf(h, "%q %q %q %q %d %q\n", host.HostID, host.Name, host.RecordType, host.Address, host.TTL, host.MXPref)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,146 @@
+package namecheap
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WithLogger logs every request to namecheap at debug level with logger.
+// The command, domain, number of hosts submitted and the response status
+// are logged. The API key is never logged.
+func WithLogger(logger *slog.Logger) ClientOption {
+	return func(c *Client) error {
+		c.logger = logger
+		return nil
+	}
+}
+
+// logRequest logs an attempt of req and its outcome if there is a logger.
+func (c *Client) logRequest(req *http.Request, attempt int, apiResp *apiResponse, err error) {
+	if c.logger == nil {
+		return
+	}
+
+	// Only pick out the parameters worth logging so the credentials never are.
+	q := req.URL.Query()
+	var hosts int
+	for key := range q {
+		if strings.HasPrefix(key, "HostName") {
+			hosts++
+		}
+	}
+
+	attrs := []slog.Attr{
+		slog.String("command", q.Get("Command")),
+		slog.Int("attempt", attempt),
+	}
+	if sld, tld := q.Get("SLD"), q.Get("TLD"); sld != "" && tld != "" {
+		attrs = append(attrs, slog.String("domain", sld+"."+tld))
+	}
+	if req.Method == http.MethodPost {
+		attrs = append(attrs, slog.Int("hosts", hosts))
+	}
+	if apiResp != nil {
+		attrs = append(attrs, slog.String("status", apiResp.Status))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", c.redact(err.Error())))
+	}
+
+	c.logger.LogAttrs(req.Context(), slog.LevelDebug, "namecheap request", attrs...)
+}
+
+// redact removes the API key from s. Errors from the HTTP client include the
+// request URL and with it the API key.
+func (c *Client) redact(s string) string {
+	if c.apiKey == "" {
+		return s
+	}
+	s = strings.ReplaceAll(s, url.QueryEscape(c.apiKey), "REDACTED")
+	return strings.ReplaceAll(s, c.apiKey, "REDACTED")
+}
+
+// WithRequestHook calls hook with the command before every request to
+// namecheap, including retries.
+func WithRequestHook(hook func(ctx context.Context, command string)) ClientOption {
+	return func(c *Client) error {
+		c.requestHook = hook
+		return nil
+	}
+}
+
+// WithResponseHook calls hook after every request to namecheap, including
+// retries, with the command, the response status, how long the request took
+// and the error it failed with if any. status is empty if namecheap didn't
+// respond. The error is still returned to the caller.
+func WithResponseHook(hook func(ctx context.Context, command, status string, d time.Duration, err error)) ClientOption {
+	return func(c *Client) error {
+		c.responseHook = hook
+		return nil
+	}
+}
+
+// Metrics receives counters and latencies of the requests made to namecheap,
+// e.g. to export them to Prometheus. Implementations must be safe for
+// concurrent use.
+type Metrics interface {
+	// IncCall counts a request for command.
+	IncCall(command string)
+	// IncError counts a failed request for command. number is the namecheap
+	// error number or empty if the request failed without one, e.g. because
+	// namecheap couldn't be reached.
+	IncError(command, number string)
+	// ObserveLatency records how long a request for command took.
+	ObserveLatency(command string, d time.Duration)
+}
+
+// WithMetrics reports every request to namecheap, including retries, to
+// metrics.
+func WithMetrics(metrics Metrics) ClientOption {
+	return func(c *Client) error {
+		c.metrics = metrics
+		return nil
+	}
+}
+
+// hookRequest calls the request hook and counts the request.
+func (c *Client) hookRequest(req *http.Request) {
+	command := req.URL.Query().Get("Command")
+	if c.requestHook != nil {
+		c.requestHook(req.Context(), command)
+	}
+	if c.metrics != nil {
+		c.metrics.IncCall(command)
+	}
+}
+
+// hookResponse calls the response hook and records the outcome in metrics.
+func (c *Client) hookResponse(req *http.Request, apiResp *apiResponse, d time.Duration, err error) {
+	command := req.URL.Query().Get("Command")
+
+	if c.responseHook != nil {
+		var status string
+		if apiResp != nil {
+			status = apiResp.Status
+		}
+		c.responseHook(req.Context(), command, status, d, err)
+	}
+
+	if c.metrics != nil {
+		c.metrics.ObserveLatency(command, d)
+		if err != nil {
+			var number string
+			var apiErr *APIError
+			if errors.As(err, &apiErr) {
+				number = strconv.Itoa(apiErr.Number)
+			}
+			c.metrics.IncError(command, number)
+		}
+	}
+}
@@ -3,12 +3,17 @@ package namecheap
 import (
 	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Provides some basic structs to interact with the Namecheap api with.
@@ -22,9 +27,24 @@ func mustParse(endpoint string) *url.URL {
 	return u
 }
 
+const (
+	// Limits on the length of names in DNS. See RFC 1035 section 2.3.4.
+	maxLabelLength = 63
+	maxNameLength  = 255
+)
+
 const (
 	defaultEndpoint         = "https://api.namecheap.com/xml.response"
 	defaultDiscoveryAddress = "https://icanhazip.com"
+
+	// The largest page of domains namecheap.domains.getList returns.
+	maxDomainsPageSize = 100
+
+	// How long requests may take by default. setHosts has to replace all the
+	// hosts of a domain so writes are given longer than reads.
+	defaultDiscoveryTimeout = 10 * time.Second
+	defaultReadTimeout      = 30 * time.Second
+	defaultWriteTimeout     = 60 * time.Second
 )
 
 var (
@@ -43,12 +63,23 @@ const (
 	MX     RecordType = "MX"
 	MXE    RecordType = "MXE"
 	NS     RecordType = "NS"
+	SRV    RecordType = "SRV"
 	TXT    RecordType = "TXT"
 	URL    RecordType = "URL"
 	URL301 RecordType = "URL301"
 	FRAME  RecordType = "FRAME"
 )
 
+// IsSupported reports whether namecheap can store records of type t.
+func (t RecordType) IsSupported() bool {
+	switch t {
+	case A, AAAA, ALIAS, CAA, CNAME, MX, MXE, NS, SRV, TXT, URL, URL301, FRAME:
+		return true
+	default:
+		return false
+	}
+}
+
 type HostRecord struct {
 	// The domain or subdomain for which host record is set.
 	Name string
@@ -60,6 +91,7 @@ type HostRecord struct {
 	Address string
 
 	// MX preference for host. Applicable for MX records only.
+	// SRV records also keep their priority here.
 	MXPref string
 
 	// 60 to 60000
@@ -105,6 +137,77 @@ func (r getHostsResponseRecord) ToHostRecord() HostRecord {
 	}
 }
 
+// DomainInfo describes a domain in the namecheap account.
+type DomainInfo struct {
+	// ID is the unique ID of the domain.
+	ID string
+
+	// Name is the domain name e.g. example.com
+	Name string
+
+	// User is the owner of the domain.
+	User string
+
+	// Created is the date the domain was registered.
+	Created time.Time
+
+	// Expires is the date the domain registration expires.
+	Expires time.Time
+
+	IsExpired bool
+	IsLocked  bool
+	AutoRenew bool
+
+	// IsPremium is true if the domain was registered as a premium domain.
+	IsPremium bool
+
+	// WhoisGuard is the status of the WhoisGuard subscription e.g. ENABLED, NOTPRESENT
+	WhoisGuard string
+}
+
+// The date format used by namecheap for the Created and Expires attributes.
+const domainDateLayout = "01/02/2006"
+
+// This gets unmarshalled from the server's XML response.
+type getListResponseDomain struct {
+	ID         string `xml:"ID,attr"`
+	Name       string `xml:"Name,attr"`
+	User       string `xml:"User,attr"`
+	Created    string `xml:"Created,attr"`
+	Expires    string `xml:"Expires,attr"`
+	IsExpired  bool   `xml:"IsExpired,attr"`
+	IsLocked   bool   `xml:"IsLocked,attr"`
+	AutoRenew  bool   `xml:"AutoRenew,attr"`
+	IsPremium  bool   `xml:"IsPremium,attr"`
+	WhoisGuard string `xml:"WhoisGuard,attr"`
+}
+
+// Converts the XML response into the public DomainInfo struct.
+func (d getListResponseDomain) ToDomainInfo() (DomainInfo, error) {
+	created, err := time.Parse(domainDateLayout, d.Created)
+	if err != nil {
+		return DomainInfo{}, fmt.Errorf("unable to parse created date of domain %s. Err: %s", d.Name, err)
+	}
+
+	expires, err := time.Parse(domainDateLayout, d.Expires)
+	if err != nil {
+		return DomainInfo{}, fmt.Errorf("unable to parse expiry date of domain %s. Err: %s", d.Name, err)
+	}
+
+	return DomainInfo{
+		ID:         d.ID,
+		Name:       d.Name,
+		User:       d.User,
+		Created:    created,
+		Expires:    expires,
+		IsExpired:  d.IsExpired,
+		IsLocked:   d.IsLocked,
+		AutoRenew:  d.AutoRenew,
+		IsPremium:  d.IsPremium,
+		WhoisGuard: d.WhoisGuard,
+	}, nil
+}
+
 // addToValues adds the HostRecord fields to values. Ignores read only fields.
 func addToValues(host HostRecord, hostNumber int, values *url.Values) {
 	setValueIfPresent := func(key, value string) {
@@ -117,27 +220,126 @@ func addToValues(host HostRecord, hostNumber int, values *url.Values) {
 	setValueIfPresent("HostName", host.Name)
 	setValueIfPresent("RecordType", string(host.RecordType))
 	setValueIfPresent("Address", string(host.Address))
-	setValueIfPresent("MXPref", host.MXPref)
+	if host.RecordType == MX || host.RecordType == SRV {
+		// namecheap defaults a missing preference to 10 so a preference of 0,
+		// common for MX and SRV records, has to be sent.
+		if host.MXPref != "" {
+			values.Set(fmt.Sprintf("MXPref%d", hostNumber), host.MXPref)
+		}
+	} else {
+		setValueIfPresent("MXPref", host.MXPref)
+	}
 	setValueIfPresent("TTL", strconv.Itoa(int(host.TTL)))
 }
 
+// validateHostName ensures the fully qualified name of the host fits within
+// the DNS label and name length limits. Namecheap rejects these
+// with an unhelpful error so it's better to catch them early.
+func validateHostName(name, domain string) error {
+	fqdn := strings.TrimSuffix(domain, ".")
+	if name != "" && name != "@" {
+		fqdn = name + "." + fqdn
+	}
+
+	if len(fqdn) > maxNameLength {
+		return fmt.Errorf("name: %s is %d characters long. Names must be at most %d characters", fqdn, len(fqdn), maxNameLength)
+	}
+
+	for _, label := range strings.Split(fqdn, ".") {
+		if len(label) > maxLabelLength {
+			return fmt.Errorf("label: %s of name: %s is %d characters long. Labels must be at most %d characters", label, fqdn, len(label), maxLabelLength)
+		}
+	}
+
+	return nil
+}
+
 // getPublicIP tries to determine the public ip of the machine by
 // making a request to an external service that returns the public
 // IP of the caller.
-func getPublicIP(discoveryAddress string) (string, error) {
-	resp, err := http.Get(discoveryAddress)
+func getPublicIP(discoveryAddress string, timeout time.Duration) (netip.Addr, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryAddress, nil)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return "", err
+		return netip.Addr{}, err
 	}
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return netip.Addr{}, fmt.Errorf("discovery service returned unexpected status: %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return netip.Addr{}, err
+	}
+
+	// Don't send whatever the service returned as the ClientIp.
+	ip, err := netip.ParseAddr(strings.TrimSpace(string(body)))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("discovery service returned an invalid IP. Err: %s", err)
 	}
 
-	return strings.TrimSpace(string(body)), nil
+	return ip.Unmap(), nil
+}
+
+// discoverPublicIP asks the discovery services in order for the public IP.
+// The first IP of the preferred address family is returned. If no service
+// returns an IP of that family, the first IP returned by any service is used.
+func (c *Client) discoverPublicIP() (string, error) {
+	var fallback string
+	var errs []string
+	for _, address := range c.discoveryAddresses {
+		ip, err := getPublicIP(address, c.discoveryTimeout)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", address, err))
+			continue
+		}
+
+		if ip.Is6() == c.discoveryPreferIPv6 {
+			return ip.String(), nil
+		}
+
+		if fallback == "" {
+			fallback = ip.String()
+		}
+	}
+
+	if fallback != "" {
+		return fallback, nil
+	}
+
+	return "", fmt.Errorf("no discovery service returned an IP. Err: %s", strings.Join(errs, "; "))
+}
+
+// currentClientIP returns the IP to send with requests. A discovered IP is
+// discovered again once it's older than the refresh interval.
+func (c *Client) currentClientIP() string {
+	c.ipMu.Lock()
+	defer c.ipMu.Unlock()
+
+	if c.autoDiscoverPublicIP && c.ipDiscoveryRefresh > 0 && time.Since(c.ipDiscoveredAt) >= c.ipDiscoveryRefresh {
+		// Keep using the previous IP if discovery fails. It's most likely
+		// still correct and failing the operation wouldn't help.
+		if ip, err := c.discoverPublicIP(); err == nil {
+			c.clientIP = ip
+		}
+		c.ipDiscoveredAt = time.Now()
+	}
+
+	return c.clientIP
 }
 
 type Client struct {
@@ -154,14 +356,130 @@ type Client struct {
 	// The API endpoint to talk to. Don't modify this. Instead create a new URL from this one.
 	endpointURL *url.URL
 
-	// An IP address of the server from which our system receives API calls (only IPv4 can be used).
+	// An IP address of the server from which our system receives API calls.
 	clientIP string
 
-	// Used to determine external IP of client.
-	discoveryAddress string
+	// Used to determine external IP of client. Tried in order.
+	discoveryAddresses []string
+
+	// Whether discovery prefers an IPv6 address over an IPv4 one.
+	discoveryPreferIPv6 bool
+
+	// Whether NewClient checks the endpoint.
+	validateEndpoint bool
+
+	// Whether domains are checked against the TLDs namecheap supports.
+	tldLookup bool
+
+	// The names of the TLDs namecheap supports. Nil until they're fetched.
+	tldMu    sync.Mutex
+	tldNames map[string]bool
 
 	// Will determine the PublicIP of the client by calling a service.
 	autoDiscoverPublicIP bool
+
+	// Used as the client IP when it can't be discovered.
+	fallbackClientIP string
+
+	// How often the discovered IP is discovered again. Zero means the IP is
+	// only discovered when the client is created.
+	ipDiscoveryRefresh time.Duration
+
+	// Guards clientIP and ipDiscoveredAt once the client is in use.
+	ipMu sync.Mutex
+
+	// When clientIP was last discovered.
+	ipDiscoveredAt time.Time
+
+	// Recently fetched hosts. Nil if caching is disabled.
+	hostsCache *HostsCache
+
+	// How long to wait for written hosts to be returned by getHosts.
+	// Zero disables waiting.
+	consistencyTimeout time.Duration
+
+	// How long to wait between getHosts while waiting for written hosts.
+	consistencyInterval time.Duration
+
+	// The total time an operation and all of its requests may take.
+	// Zero means no limit other than the caller's context.
+	operationBudget time.Duration
+
+	// How long a single request may take. Zero means no limit other than the caller's context.
+	discoveryTimeout time.Duration
+	readTimeout      time.Duration
+	writeTimeout     time.Duration
+
+	// How many times a request is attempted. Values below 2 disable retries.
+	maxAttempts int
+
+	// The delay before the first retry. It doubles with every retry.
+	retryBaseDelay time.Duration
+
+	// namecheap error numbers that are worth retrying.
+	retryableErrors map[int]bool
+
+	// Limits the rate of requests. Nil if requests aren't limited.
+	rateLimiter *RateLimiter
+
+	// The EmailType sent with setHosts. Empty means it's derived from the hosts.
+	emailType string
+
+	// namecheap error numbers that mean a feature requires Premium DNS.
+	premiumDNSErrors map[int]bool
+
+	// namecheap error numbers that mean a quota was exceeded, mapped to the quota's window.
+	rateLimitErrors map[int]time.Duration
+
+	// The longest a rate limited request waits for its quota's window before
+	// being retried. Requests limited for longer fail right away.
+	maxRateLimitWait time.Duration
+
+	// The largest setHosts request in bytes.
+	maxRequestSize int
+
+	// The most hosts a setHosts request may carry. Zero means no limit.
+	maxHosts int
+
+	// Receives warnings about requests that are close to namecheap's limits. May be nil.
+	warn func(warning string)
+
+	// Whether hosts are read again to check for changes before writing them.
+	optimisticLocking bool
+
+	// Whether writes fail for domains that don't use namecheap's nameservers.
+	requireNamecheapDNS bool
+
+	// Whether getHosts last reported each domain uses namecheap's nameservers
+	// and the EmailType it reported for each domain.
+	dnsMu          sync.Mutex
+	usingOurDNS    map[string]bool
+	zoneEmailTypes map[string]string
+
+	// Whether writes are skipped. Hosts are still fetched and merged.
+	dryRun bool
+
+	// Receives the hosts that would have been written in dry-run mode. May be nil.
+	dryRunHook func(domain string, hosts []HostRecord)
+
+	// Whether DeleteHosts returns ErrNothingDeleted when no host matched.
+	noopDeleteError bool
+
+	// Receives the parameters of every setHosts request. Nil if they aren't dumped.
+	requestDump io.Writer
+
+	// Logs requests at debug level. Nil if requests aren't logged.
+	logger *slog.Logger
+
+	// Called before and after every request. May be nil.
+	requestHook  func(ctx context.Context, command string)
+	responseHook func(ctx context.Context, command, status string, d time.Duration, err error)
+
+	// Receives counters and latencies of requests. Nil if they aren't recorded.
+	metrics Metrics
+
+	// Converts responses that aren't UTF-8 to UTF-8. Nil if only UTF-8 is accepted.
+	charsetReader func(charset string, input io.Reader) (io.Reader, error)
 }
 
 type ClientOption func(*Client) error
@@ -178,6 +496,27 @@ func WithEndpoint(endpoint string) ClientOption {
 	}
 }
 
+// WithEndpointValidation makes NewClient check that the endpoint is an
+// absolute http or https URL so a misconfigured endpoint fails when the
+// client is created instead of on the first request.
+func WithEndpointValidation() ClientOption {
+	return func(c *Client) error {
+		c.validateEndpoint = true
+		return nil
+	}
+}
+
+// checkEndpoint reports why u can't be used as the endpoint, if it can't.
+func checkEndpoint(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("endpoint: %s is not a valid endpoint. Expected an http or https URL", u)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("endpoint: %s is not a valid endpoint. Expected a host", u)
+	}
+	return nil
+}
+
 func WithClientIP(ip string) ClientOption {
 	return func(c *Client) error {
 		c.clientIP = ip
@@ -187,25 +526,216 @@ func WithClientIP(ip string) ClientOption {
 
 func WithDiscoveryAddress(address string) ClientOption {
 	return func(c *Client) error {
-		c.discoveryAddress = address
+		c.discoveryAddresses = []string{address}
 		return nil
 	}
 }
 
-func AutoDiscoverPublicIP() ClientOption {
+// AutoDiscoverPublicIP determines the client IP by asking a discovery service
+// for the public IP of the machine. If addresses are given, those services are
+// tried in order instead of the default one until one of them returns an IP.
+func AutoDiscoverPublicIP(addresses ...string) ClientOption {
 	return func(c *Client) error {
 		c.autoDiscoverPublicIP = true
+		if len(addresses) > 0 {
+			c.discoveryAddresses = addresses
+		}
+		return nil
+	}
+}
+
+// WithFallbackClientIP uses ip as the client IP when AutoDiscoverPublicIP
+// can't discover the public IP instead of failing to create the client. Using
+// the fallback is reported through WithWarnings.
+func WithFallbackClientIP(ip string) ClientOption {
+	return func(c *Client) error {
+		c.fallbackClientIP = ip
+		return nil
+	}
+}
+
+// WithDiscoveryPreferIPv6 prefers an IPv6 public IP over an IPv4 one when
+// discovering the public IP. The discovery services are tried until one
+// returns an IPv6 address. If none does, an IPv4 address is used.
+func WithDiscoveryPreferIPv6() ClientOption {
+	return func(c *Client) error {
+		c.discoveryPreferIPv6 = true
+		return nil
+	}
+}
+
+// WithIPDiscoveryRefresh discovers the public IP again when it was last
+// discovered more than refresh ago. This lets long-lived clients follow
+// changes of the public IP. If discovering the IP again fails, the previously
+// discovered IP is used. Only applies with AutoDiscoverPublicIP.
+func WithIPDiscoveryRefresh(refresh time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.ipDiscoveryRefresh = refresh
+		return nil
+	}
+}
+
+// WithHostsCache caches the hosts fetched by the client in cache.
+// The cached hosts are returned by GetHosts and used by the read-modify-write
+// operations instead of fetching them again. The cache is invalidated whenever
+// hosts are set. The cache can be shared by multiple clients.
+func WithHostsCache(cache *HostsCache) ClientOption {
+	return func(c *Client) error {
+		c.hostsCache = cache
+		return nil
+	}
+}
+
+// WithConsistencyCheck makes the client wait after setting hosts until
+// getHosts returns the hosts that were set. namecheap doesn't always return
+// the new hosts immediately after they're set. getHosts is retried every
+// interval until timeout is reached. Hosts namecheap stored with a different
+// TTL than the one written are reported through WithWarnings.
+func WithConsistencyCheck(timeout, interval time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.consistencyTimeout = timeout
+		c.consistencyInterval = interval
+		return nil
+	}
+}
+
+// WithOperationBudget limits the total time an operation such as AddHosts
+// may take. The budget is shared by all the requests the operation makes so
+// a slow getHosts leaves less time for the setHosts that follows it.
+func WithOperationBudget(budget time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.operationBudget = budget
+		return nil
+	}
+}
+
+// The email types namecheap accepts with setHosts.
+var emailTypes = map[string]bool{
+	"MX":    true,
+	"MXE":   true,
+	"FWD":   true,
+	"OX":    true,
+	"GMAIL": true,
+	"NONE":  true,
+}
+
+// WithEmailType sets the EmailType sent with every setHosts request e.g. FWD
+// to use namecheap's email forwarding. By default MX is sent when there are
+// MX hosts and otherwise the EmailType getHosts last reported for the domain
+// is sent back so writes don't reset the domain's email settings.
+func WithEmailType(emailType string) ClientOption {
+	return func(c *Client) error {
+		emailType = strings.ToUpper(emailType)
+		if !emailTypes[emailType] {
+			return fmt.Errorf("email type: %s is not one of MX, MXE, FWD, OX, GMAIL or NONE", emailType)
+		}
+		c.emailType = emailType
+		return nil
+	}
+}
+
+// WithDryRun skips every setHosts request. Hosts are still fetched and the
+// changes are merged with them as usual and the methods return the hosts that
+// would have been written. If hook isn't nil, it is called with the complete
+// set of hosts each setHosts request would have sent.
+func WithDryRun(hook func(domain string, hosts []HostRecord)) ClientOption {
+	return func(c *Client) error {
+		c.dryRun = true
+		c.dryRunHook = hook
+		return nil
+	}
+}
+
+// ErrNothingDeleted is returned by DeleteHosts when none of the hosts
+// matched an existing host and the WithNoopDeleteError option is used.
+var ErrNothingDeleted = errors.New("no hosts matched the hosts to delete")
+
+// WithNoopDeleteError makes DeleteHosts return ErrNothingDeleted along with
+// the existing hosts when none of the hosts to delete exist. This lets
+// callers tell a delete that had no effect from one that removed hosts.
+func WithNoopDeleteError() ClientOption {
+	return func(c *Client) error {
+		c.noopDeleteError = true
+		return nil
+	}
+}
+
+// WithRequestTimeout limits how long each request to namecheap, including
+// the request to discover the public IP, may take. Each attempt of a retried
+// request gets the full timeout. It sets the discovery, read and write
+// timeouts at once. Zero removes the limits.
+func WithRequestTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.discoveryTimeout = timeout
+		c.readTimeout = timeout
+		c.writeTimeout = timeout
+		return nil
+	}
+}
+
+// WithDiscoveryTimeout limits how long each request to discover the public IP
+// may take. Defaults to 10 seconds. Zero removes the limit.
+func WithDiscoveryTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.discoveryTimeout = timeout
+		return nil
+	}
+}
+
+// WithReadTimeout limits how long each request that only reads from
+// namecheap, such as getHosts, may take. Defaults to 30 seconds. Zero removes
+// the limit.
+func WithReadTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.readTimeout = timeout
+		return nil
+	}
+}
+
+// WithWriteTimeout limits how long each request that makes changes, such as
+// setHosts, may take. Defaults to 60 seconds. Zero removes the limit.
+func WithWriteTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.writeTimeout = timeout
+		return nil
+	}
+}
+
+// WithRequestDump writes the URL encoded parameters of every setHosts request
+// to w, one request per line, with the API key redacted. This is useful to
+// reproduce problems when reporting bugs.
+func WithRequestDump(w io.Writer) ClientOption {
+	return func(c *Client) error {
+		c.requestDump = w
+		return nil
+	}
+}
+
+// WithXMLDecoder sets the CharsetReader used when decoding responses.
+// namecheap responds in UTF-8 but a proxy may transcode the responses.
+// charsetReader converts a response declared in charset to UTF-8.
+// By default responses in any encoding other than UTF-8 are rejected.
+func WithXMLDecoder(charsetReader func(charset string, input io.Reader) (io.Reader, error)) ClientOption {
+	return func(c *Client) error {
+		c.charsetReader = charsetReader
 		return nil
 	}
 }
 
 func NewClient(apiKey, apiUser string, opts ...ClientOption) (*Client, error) {
 	client := &Client{
-		apiKey:           apiKey,
-		apiUser:          apiUser,
-		endpointURL:      defaultEndpointURL,
-		username:         apiUser,
-		discoveryAddress: defaultDiscoveryAddress,
+		apiKey:             apiKey,
+		apiUser:            apiUser,
+		endpointURL:        defaultEndpointURL,
+		username:           apiUser,
+		discoveryAddresses: []string{defaultDiscoveryAddress},
+		maxAttempts:        1,
+		discoveryTimeout:   defaultDiscoveryTimeout,
+		readTimeout:        defaultReadTimeout,
+		writeTimeout:       defaultWriteTimeout,
+		maxRequestSize:     defaultMaxRequestSize,
+		retryableErrors:    defaultRetryableErrors(),
+		maxRateLimitWait:   defaultMaxRateLimitWait,
 	}
 
 	for _, opt := range opts {
@@ -214,12 +744,26 @@ func NewClient(apiKey, apiUser string, opts ...ClientOption) (*Client, error) {
 		}
 	}
 
+	if client.validateEndpoint {
+		if err := checkEndpoint(client.endpointURL); err != nil {
+			return nil, err
+		}
+	}
+
 	if client.autoDiscoverPublicIP {
-		ip, err := getPublicIP(client.discoveryAddress)
-		if err != nil {
+		ip, err := client.discoverPublicIP()
+		switch {
+		case err == nil:
+		case client.fallbackClientIP != "":
+			ip = client.fallbackClientIP
+			if client.warn != nil {
+				client.warn(fmt.Sprintf("unable to determine public IP automatically, using fallback IP: %s. Err: %s", ip, err))
+			}
+		default:
 			return nil, fmt.Errorf("unable to determine public IP automatically. Err: %s", err)
 		}
 		client.clientIP = ip
+		client.ipDiscoveredAt = time.Now()
 	}
 
 	if client.clientIP == "" {
@@ -230,8 +774,32 @@ func NewClient(apiKey, apiUser string, opts ...ClientOption) (*Client, error) {
 }
 
 // GetHosts returns the host records for the given domain.
+// If the client has a hosts cache, recently fetched hosts are
+// returned from the cache instead of being fetched again.
 func (c *Client) GetHosts(ctx context.Context, domain string) ([]HostRecord, error) {
-	u, err := c.buildURL("namecheap.domains.dns.getHosts", domain)
+	if c.hostsCache != nil {
+		if hosts, found := c.hostsCache.get(domain); found {
+			return hosts, nil
+		}
+	}
+
+	return c.fetchHosts(ctx, domain)
+}
+
+// fetchHosts gets the host records for the given domain from namecheap
+// and updates the hosts cache.
+func (c *Client) fetchHosts(ctx context.Context, domain string) ([]HostRecord, error) {
+	ctx, cancel := c.withBudget(ctx)
+	defer cancel()
+
+	// The generation is taken before the request so that if a write
+	// happens while it's in flight the hosts it returns aren't cached.
+	var generation uint64
+	if c.hostsCache != nil {
+		generation = c.hostsCache.generation(domain)
+	}
+
+	u, err := c.buildURL(ctx, "namecheap.domains.dns.getHosts", domain)
 	if err != nil {
 		return nil, err
 	}
@@ -241,149 +809,817 @@ func (c *Client) GetHosts(ctx context.Context, domain string) ([]HostRecord, err
 		return nil, err
 	}
 
-	apiResp, err := doRequest(req)
+	apiResp, err := c.doRequest(req)
 	if err != nil {
 		return nil, err
 	}
 
+	result := apiResp.CommandResponse.DomainDNSGetHostsResult
+	c.setUsingOurDNS(domain, result.IsUsingOurDNS)
+	c.setZoneEmailType(domain, result.EmailType)
+
 	var records []HostRecord
-	for _, host := range apiResp.CommandResponse.DomainDNSGetHostsResult.Hosts {
+	for _, host := range result.Hosts {
 		records = append(records, host.ToHostRecord())
 	}
 
+	if c.hostsCache != nil {
+		c.hostsCache.set(domain, records, generation)
+	}
+
 	return records, nil
 }
 
-// AddHosts adds the host records for the given domain.
-func (c *Client) AddHosts(ctx context.Context, domain string, hosts []HostRecord) ([]HostRecord, error) {
-	// Need to first get the existing hosts before adding new ones since we can only "set hosts" in namecheap api.
-	existingHosts, err := c.GetHosts(ctx, domain)
+// GetDomains returns the domains in the account.
+// All the pages of domains are fetched.
+func (c *Client) GetDomains(ctx context.Context) ([]DomainInfo, error) {
+	ctx, cancel := c.withBudget(ctx)
+	defer cancel()
+
+	var domains []DomainInfo
+	for page := 1; ; page++ {
+		params := url.Values{}
+		params.Set("Page", strconv.Itoa(page))
+		params.Set("PageSize", strconv.Itoa(maxDomainsPageSize))
+		u := c.buildCommandURL("namecheap.domains.getList", params)
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		apiResp, err := c.doRequest(req)
+		if err != nil {
+			return nil, err
+		}
+
+		result := apiResp.CommandResponse.DomainGetListResult
+		if result == nil || len(result.Domains) == 0 {
+			return domains, nil
+		}
+
+		for _, d := range result.Domains {
+			domain, err := d.ToDomainInfo()
+			if err != nil {
+				return nil, err
+			}
+			domains = append(domains, domain)
+		}
+
+		paging := apiResp.CommandResponse.Paging
+		if paging == nil || paging.CurrentPage*paging.PageSize >= paging.TotalItems {
+			return domains, nil
+		}
+	}
+}
+
+// GetNameservers returns the nameservers the domain is delegated to and
+// whether they are namecheap's own nameservers. namecheap only serves the
+// hosts managed with setHosts when it is.
+func (c *Client) GetNameservers(ctx context.Context, domain Domain) ([]string, bool, error) {
+	ctx, cancel := c.withBudget(ctx)
+	defer cancel()
+
+	params, err := domainParams(domain)
+	if err != nil {
+		return nil, false, err
+	}
+
+	u := c.buildCommandURL("namecheap.domains.dns.getList", params)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	apiResp, err := c.doRequest(req)
+	if err != nil {
+		return nil, false, err
+	}
+
+	result := apiResp.CommandResponse.DomainDNSGetListResult
+	if result == nil {
+		return nil, false, fmt.Errorf("namecheap api returned no nameservers for domain: %s", domain)
+	}
+
+	return result.Nameservers, result.IsUsingOurDNS, nil
+}
+
+// domainParams returns the params that identify domain in a command.
+func domainParams(domain Domain) (url.Values, error) {
+	if domain.SLD == "" || domain.TLD == "" {
+		return nil, fmt.Errorf("domain: %s is not a valid domain. Expected at least 1 TLD and 1 SLD", domain)
+	}
+
+	params := url.Values{}
+	params.Set("TLD", domain.TLD)
+	params.Set("SLD", domain.SLD)
+	return params, nil
+}
+
+// SetDefaultNS switches the domain to namecheap's default nameservers.
+// namecheap only serves the hosts managed with setHosts when the domain uses
+// its nameservers. Nothing is changed in dry-run mode.
+func (c *Client) SetDefaultNS(ctx context.Context, domain Domain) error {
+	apiResp, err := c.setNameservers(ctx, "namecheap.domains.dns.setDefault", domain, nil)
+	if err != nil || apiResp == nil {
+		return err
+	}
+
+	result := apiResp.CommandResponse.DomainDNSSetDefaultResult
+	if result == nil || !result.Updated {
+		return fmt.Errorf("namecheap api did not switch domain: %s to the default nameservers", domain)
+	}
+
+	c.setUsingOurDNS(domain.String(), true)
+
+	return nil
+}
+
+// The fewest custom nameservers namecheap accepts for a domain.
+const minCustomNameservers = 2
+
+// SetCustomNS delegates the domain to the given nameservers e.g. to serve it
+// from another DNS provider. namecheap requires at least 2 nameservers. The
+// hosts managed with setHosts aren't served while the domain uses custom
+// nameservers. Nothing is changed in dry-run mode.
+func (c *Client) SetCustomNS(ctx context.Context, domain Domain, nameservers []string) error {
+	if len(nameservers) < minCustomNameservers {
+		return fmt.Errorf("domain: %s needs at least %d custom nameservers. Got: %d", domain, minCustomNameservers, len(nameservers))
+	}
+
+	for _, ns := range nameservers {
+		if ns == "" || strings.Contains(ns, ",") {
+			return fmt.Errorf("nameserver: %q is not a valid nameserver", ns)
+		}
+	}
+
+	apiResp, err := c.setNameservers(ctx, "namecheap.domains.dns.setCustom", domain, url.Values{
+		"Nameservers": {strings.Join(nameservers, ",")},
+	})
+	if err != nil || apiResp == nil {
+		return err
+	}
+
+	result := apiResp.CommandResponse.DomainDNSSetCustomResult
+	if result == nil || !result.Updated {
+		return fmt.Errorf("namecheap api did not switch domain: %s to custom nameservers", domain)
+	}
+
+	c.setUsingOurDNS(domain.String(), false)
+
+	return nil
+}
+
+// setNameservers sends a command that changes the nameservers of domain with
+// the given extra params. No response is returned in dry-run mode.
+func (c *Client) setNameservers(ctx context.Context, command string, domain Domain, extra url.Values) (*apiResponse, error) {
+	ctx, cancel := c.withBudget(ctx)
+	defer cancel()
+
+	params, err := domainParams(domain)
 	if err != nil {
 		return nil, err
 	}
 
-	// Add the hosts to the existing hosts to try and preserve the original order.
-	existingHosts = append(existingHosts, hosts...)
-	_, err = c.setHosts(ctx, domain, existingHosts)
+	for k, v := range extra {
+		params[k] = v
+	}
+	u := c.buildCommandURL(command, params)
+
+	if c.requestDump != nil {
+		c.dumpRequest(u)
+	}
+
+	if c.dryRun {
+		return nil, nil
+	}
+
+	// The hosts namecheap serves may change along with the nameservers.
+	if c.hostsCache != nil {
+		c.hostsCache.invalidate(domain.String())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	return hosts, err
+	return c.doRequest(req)
 }
 
-// DeleteHosts removes the host records for the given domain.
-// Deletes the hosts by HostID. Deleting a host that does not exist
-// has no effect.
-func (c *Client) DeleteHosts(ctx context.Context, domain string, hosts []HostRecord) ([]HostRecord, error) {
-	existingHosts, err := c.GetHosts(ctx, domain)
+// AddHosts adds the host records for the given domain.
+func (c *Client) AddHosts(ctx context.Context, domain string, hosts []HostRecord) ([]HostRecord, error) {
+	ctx, cancel := c.withBudget(ctx)
+	defer cancel()
+
+	// Need to first get the existing hosts before adding new ones since we can only "set hosts" in namecheap api.
+	_, err := c.readModifyWrite(ctx, domain, func(existingHosts []HostRecord) ([]HostRecord, bool, error) {
+		// Add the hosts to the existing hosts to try and preserve the original order.
+		return append(existingHosts, hosts...), true, nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	return hosts, err
+}
+
+// DeleteHosts removes the host records for the given domain.
+// Hosts with a HostID are deleted by ID. Hosts without one are deleted by
+// value and remove every existing host with the same name, type and address.
+// Deleting a host that does not exist, including one with a stale HostID,
+// has no effect unless the client was created with WithNoopDeleteError.
+func (c *Client) DeleteHosts(ctx context.Context, domain string, hosts []HostRecord) ([]HostRecord, error) {
+	updatedHosts, _, err := c.deleteHosts(ctx, domain, hosts)
+	return updatedHosts, err
+}
+
+// RemoveHosts removes hosts from the domain like DeleteHosts but returns the
+// existing hosts that were removed instead of the hosts that remain. Hosts
+// that didn't exist aren't returned so the result is empty if nothing was
+// removed.
+func (c *Client) RemoveHosts(ctx context.Context, domain string, hosts []HostRecord) ([]HostRecord, error) {
+	_, removedHosts, err := c.deleteHosts(ctx, domain, hosts)
+	return removedHosts, err
+}
+
+// deleteHosts returns the hosts left after the delete and the hosts that
+// were removed.
+func (c *Client) deleteHosts(ctx context.Context, domain string, hosts []HostRecord) ([]HostRecord, []HostRecord, error) {
+	ctx, cancel := c.withBudget(ctx)
+	defer cancel()
+
+	// Hosts with an ID are matched only by it so hosts with the same content
+	// are never mistaken for each other and a stale ID matches nothing. Hosts
+	// without an ID fall back to matching by value.
 	var hostsToRemoveByID = make(map[string]HostRecord)
+	var hostsToRemoveByValue = make(map[string]HostRecord)
 	for _, host := range hosts {
-		hostsToRemoveByID[host.HostID] = host
+		if host.HostID != "" {
+			hostsToRemoveByID[host.HostID] = host
+		} else {
+			hostsToRemoveByValue[HostKey(host)] = host
+		}
 	}
 
-	// Build the array from only existing hosts that aren't being removed.
-	var updatedHosts []HostRecord
-	for _, host := range existingHosts {
-		if _, found := hostsToRemoveByID[host.HostID]; !found {
-			updatedHosts = append(updatedHosts, host)
+	// The hosts are read again if the write has to be retried so the removed
+	// hosts are collected from the last read only.
+	var removedHosts []HostRecord
+	updatedHosts, err := c.readModifyWrite(ctx, domain, func(existingHosts []HostRecord) ([]HostRecord, bool, error) {
+		removedHosts = []HostRecord{}
+
+		// Build the array from only existing hosts that aren't being removed.
+		var updatedHosts []HostRecord
+		for _, host := range existingHosts {
+			_, foundByID := hostsToRemoveByID[host.HostID]
+			_, foundByValue := hostsToRemoveByValue[HostKey(host)]
+			if foundByID || foundByValue {
+				removedHosts = append(removedHosts, host)
+			} else {
+				updatedHosts = append(updatedHosts, host)
+			}
 		}
+
+		// Nothing to remove so there's no need to rewrite the hosts.
+		if len(removedHosts) == 0 {
+			if c.noopDeleteError {
+				return existingHosts, false, ErrNothingDeleted
+			}
+			return existingHosts, false, nil
+		}
+
+		return updatedHosts, true, nil
+	})
+	if err != nil {
+		return updatedHosts, nil, err
 	}
+	return updatedHosts, removedHosts, nil
+}
 
-	return c.setHosts(ctx, domain, updatedHosts)
+// HostKey identifies a host by its name, type and address, since namecheap
+// assigns new HostIDs to every host on each write. Names are compared
+// case-insensitively like DNS does.
+func HostKey(host HostRecord) string {
+	return fmt.Sprintf("%s|%s|%s", strings.ToLower(host.Name), host.RecordType, host.Address)
 }
 
+// ReplaceHosts replaces all the host records for the given domain with hosts.
+// Any existing host not in hosts is removed.
+func (c *Client) ReplaceHosts(ctx context.Context, domain string, hosts []HostRecord) ([]HostRecord, error) {
+	ctx, cancel := c.withBudget(ctx)
+	defer cancel()
+
+	return c.setHosts(ctx, domain, hosts)
+}
+
+// setHosts writes hosts as the complete set of hosts for the domain.
+//
+// The namecheap API has no way to add, update or remove an individual host.
+// namecheap.domains.dns.setHosts is the only write command and it always
+// replaces every host of the domain; hosts can't be referenced by HostID to
+// leave them unchanged. Every change is therefore a full read-modify-write,
+// so callers should avoid calling this when the hosts haven't changed.
+// See: https://www.namecheap.com/support/api/methods/domains-dns/set-hosts/
 func (c *Client) setHosts(ctx context.Context, domain string, hosts []HostRecord) ([]HostRecord, error) {
-	u, err := c.buildURL("namecheap.domains.dns.setHosts", domain, hosts...)
+	hostTypes := make(map[RecordType]bool)
+	for _, host := range hosts {
+		if err := validateHostName(host.Name, domain); err != nil {
+			return nil, err
+		}
+		hostTypes[host.RecordType] = true
+	}
+
+	// The email type is either MX or MXE so namecheap can't serve both.
+	if hostTypes[MX] && hostTypes[MXE] {
+		return nil, fmt.Errorf("domain: %s can't have both MX and MXE hosts. Remove the MXE hosts to use MX hosts or the other way around", domain)
+	}
+
+	if err := checkCNAMEConflicts(domain, hosts); err != nil {
+		return nil, err
+	}
+
+	if err := checkApexNS(domain, hosts); err != nil {
+		return nil, err
+	}
+
+	if err := c.checkHostCount(domain, len(hosts)); err != nil {
+		return nil, err
+	}
+
+	if err := c.checkUsingOurDNS(domain); err != nil {
+		return nil, err
+	}
+
+	u, err := c.buildURL(ctx, "namecheap.domains.dns.setHosts", domain, hosts...)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := c.checkRequestSize(domain, len(u.RawQuery), len(hosts)); err != nil {
+		return nil, err
+	}
+
+	if c.requestDump != nil {
+		c.dumpRequest(u)
+	}
+
+	if c.dryRun {
+		if c.dryRunHook != nil {
+			c.dryRunHook(domain, append([]HostRecord(nil), hosts...))
+		}
+		return hosts, nil
+	}
+
+	// The new hosts are assigned IDs by namecheap so they need to be fetched
+	// again. The cache is invalidated both before and after the write since
+	// hosts fetched concurrently while the write is in flight may be from
+	// before it.
+	if c.hostsCache != nil {
+		c.hostsCache.invalidate(domain)
+	}
+
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = doRequest(req)
-	return hosts, err
+	_, err = c.doRequest(req)
+	if c.hostsCache != nil {
+		c.hostsCache.invalidate(domain)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.consistencyTimeout > 0 {
+		return c.waitForHosts(ctx, domain, hosts)
+	}
+
+	return hosts, nil
 }
 
-// SetHosts creates or updates existing hosts. Existing hosts must have a host ID
-// otherwise the record is treated as a new host. Does not delete any existing hosts.
-func (c *Client) SetHosts(ctx context.Context, domain string, hosts []HostRecord) ([]HostRecord, error) {
-	existingHosts, err := c.GetHosts(ctx, domain)
-	if err != nil {
-		return nil, nil
+// waitForHosts polls getHosts until it returns the expected hosts. The hosts
+// returned by getHosts are returned since they have their IDs set.
+func (c *Client) waitForHosts(ctx context.Context, domain string, expected []HostRecord) ([]HostRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.consistencyTimeout)
+	defer cancel()
+
+	for {
+		// The cache was invalidated by the write but another caller may have
+		// already filled it with hosts from before the write.
+		hosts, err := c.fetchHosts(ctx, domain)
+		if err != nil {
+			return nil, err
+		}
+
+		if sameHosts(expected, hosts) {
+			c.warnAdjustedTTLs(domain, expected, hosts)
+			return hosts, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("hosts for domain: %s were not updated within %s. Err: %s", domain, c.consistencyTimeout, ctx.Err())
+		case <-time.After(c.consistencyInterval):
+		}
+	}
+}
+
+// sameHosts reports whether a and b contain the same hosts ignoring
+// their order and the fields namecheap fills in such as HostID.
+func sameHosts(a, b []HostRecord) bool {
+	if len(a) != len(b) {
+		return false
 	}
 
-	var existingHostsByID = make(map[string]*HostRecord)
-	for i := range existingHosts {
-		existingHostsByID[existingHosts[i].HostID] = &existingHosts[i]
+	counts := make(map[string]int)
+	for _, host := range a {
+		counts[HostKey(host)]++
 	}
 
-	var newHosts []HostRecord
+	for _, host := range b {
+		k := HostKey(host)
+		if counts[k] == 0 {
+			return false
+		}
+		counts[k]--
+	}
+
+	return true
+}
+
+// checkCNAMEConflicts returns an error if a CNAME host shares its name with
+// another host. DNS doesn't allow a CNAME next to other records and
+// namecheap's rejection doesn't say which host is at fault.
+func checkCNAMEConflicts(domain string, hosts []HostRecord) error {
+	typesByName := make(map[string][]RecordType)
+	var names []string
 	for _, host := range hosts {
-		if existingHost, found := existingHostsByID[host.HostID]; found {
-			// This will update the value in existingHosts
-			*existingHost = host
-		} else {
-			newHosts = append(newHosts, host)
+		name := strings.ToLower(host.Name)
+		if _, found := typesByName[name]; !found {
+			names = append(names, name)
 		}
+		typesByName[name] = append(typesByName[name], host.RecordType)
 	}
 
-	existingHosts = append(existingHosts, newHosts...)
+	for _, name := range names {
+		types := typesByName[name]
+		if len(types) < 2 {
+			continue
+		}
+		for _, recordType := range types {
+			if recordType == CNAME {
+				return fmt.Errorf("host: %s of domain: %s has a CNAME along with other hosts. A CNAME can't share its name with any other host. Types: %v", name, domain, types)
+			}
+		}
+	}
 
-	return c.setHosts(ctx, domain, existingHosts)
+	return nil
 }
 
-// buildURL builds a URL needed to talk to the namecheap API based on the query params.
-func (c *Client) buildURL(command, domain string, hosts ...HostRecord) (*url.URL, error) {
+// checkApexNS returns an error if there's an NS host at the apex of the
+// domain. namecheap serves its own nameservers for the domains it hosts so NS
+// hosts can only delegate subdomains.
+func checkApexNS(domain string, hosts []HostRecord) error {
+	for _, host := range hosts {
+		if host.RecordType == NS && host.Name == "@" {
+			return fmt.Errorf("domain: %s can't have NS hosts at its apex. NS hosts can only delegate subdomains, the domain's own nameservers are changed in its registration instead. Address: %s", domain, host.Address)
+		}
+	}
+	return nil
+}
+
+// warnAdjustedTTLs warns about each host that namecheap stored with a TTL
+// other than the one written. Hosts written without a TTL get namecheap's
+// default so they aren't checked.
+func (c *Client) warnAdjustedTTLs(domain string, written, stored []HostRecord) {
+	if c.warn == nil {
+		return
+	}
+
+	storedTTLs := make(map[string][]uint16)
+	for _, host := range stored {
+		storedTTLs[HostKey(host)] = append(storedTTLs[HostKey(host)], host.TTL)
+	}
+
+	for _, host := range written {
+		ttls := storedTTLs[HostKey(host)]
+		if host.TTL == 0 || len(ttls) == 0 {
+			continue
+		}
+
+		// Duplicate hosts are told apart by their TTL where possible.
+		i := 0
+		for j, ttl := range ttls {
+			if ttl == host.TTL {
+				i = j
+				break
+			}
+		}
+		if ttls[i] != host.TTL {
+			c.warn(fmt.Sprintf("%s host: %s of domain: %s was written with a TTL of %d seconds but namecheap stored %d seconds", host.RecordType, host.Name, domain, host.TTL, ttls[i]))
+		}
+		storedTTLs[HostKey(host)] = append(ttls[:i], ttls[i+1:]...)
+	}
+}
+
+// SetHosts creates or updates existing hosts. Existing hosts must have a host ID
+// otherwise the record is treated as a new host. Does not delete any existing hosts.
+func (c *Client) SetHosts(ctx context.Context, domain string, hosts []HostRecord) ([]HostRecord, error) {
+	ctx, cancel := c.withBudget(ctx)
+	defer cancel()
+
+	return c.readModifyWrite(ctx, domain, func(existingHosts []HostRecord) ([]HostRecord, bool, error) {
+		// Hosts are matched only by HostID so duplicate hosts, which namecheap
+		// gives different IDs, are updated independently. Hosts without an ID
+		// are always added.
+		var existingHostsByID = make(map[string]*HostRecord)
+		for i := range existingHosts {
+			if existingHosts[i].HostID != "" {
+				existingHostsByID[existingHosts[i].HostID] = &existingHosts[i]
+			}
+		}
+
+		var changed bool
+		var newHosts []HostRecord
+		for _, host := range hosts {
+			if existingHost, found := existingHostsByID[host.HostID]; found {
+				if *existingHost != host {
+					// This will update the value in existingHosts
+					*existingHost = host
+					changed = true
+				}
+			} else {
+				newHosts = append(newHosts, host)
+				changed = true
+			}
+		}
+
+		// All the hosts are already set so there's no need to rewrite them.
+		return append(existingHosts, newHosts...), changed, nil
+	})
+}
+
+// dumpRequest writes the parameters of the request to the request dump
+// with the API key redacted. Failing to write the dump doesn't fail the request.
+func (c *Client) dumpRequest(u *url.URL) {
+	q := u.Query()
+	q.Set("ApiKey", "REDACTED")
+	fmt.Fprintln(c.requestDump, q.Encode())
+}
+
+// withBudget returns a context limited to the operation budget. Operations
+// started from within another operation share the outer operation's budget.
+func (c *Client) withBudget(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.operationBudget <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.operationBudget)
+}
+
+// Domain is a registrable domain split into the parts namecheap expects.
+type Domain struct {
+	// SLD is the second-level domain e.g. example for example.co.uk
+	SLD string
+
+	// TLD is the top-level domain e.g. co.uk for example.co.uk
+	TLD string
+}
+
+// ParseDomain splits a registrable domain such as example.com. into its SLD
+// and TLD.
+func ParseDomain(domain string) (Domain, error) {
+	sld, tld, err := splitDomain(domain)
+	if err != nil {
+		return Domain{}, err
+	}
+	return Domain{SLD: sld, TLD: tld}, nil
+}
+
+// String returns the domain name e.g. example.co.uk
+func (d Domain) String() string {
+	return d.SLD + "." + d.TLD
+}
+
+// splitDomain splits a registrable domain into the SLD and TLD namecheap
+// expects, failing for anything that can't be registered such as a bare TLD
+// or an IP address.
+func splitDomain(domain string) (sld, tld string, err error) {
 	// example.com. should be SLD: example TLD: com
 	// example.co.uk should be SLD: example TLD: co.uk
-	if strings.HasSuffix(domain, ".") {
-		domain = domain[:len(domain)-1]
+	domain = strings.TrimSuffix(domain, ".")
+
+	if domain == "" {
+		return "", "", errors.New("domain is empty. Expected a registrable domain such as example.com")
+	}
+
+	if _, err := netip.ParseAddr(domain); err == nil {
+		return "", "", fmt.Errorf("domain: %s is an IP address. Expected a registrable domain such as example.com", domain)
 	}
 
 	split_domain := strings.Split(domain, ".")
 	if len(split_domain) < 2 {
-		return nil, fmt.Errorf("domain: %s is not a valid domain. Expected at least 1 TLD and 1 SLD", domain)
+		return "", "", fmt.Errorf("domain: %s is not a valid domain. Expected at least 1 TLD and 1 SLD", domain)
+	}
+
+	for _, label := range split_domain {
+		if label == "" {
+			return "", "", fmt.Errorf("domain: %s is not a valid domain. Expected no empty labels", domain)
+		}
+	}
+
+	sld = split_domain[0]
+	// The SLD is a single label so everything else is the TLD. That's wrong
+	// for subdomains such as www.example.com which WithTLDLookup rejects.
+	tld = strings.Join(split_domain[1:], ".")
+
+	return sld, tld, nil
+}
+
+// buildURL builds a URL needed to talk to the namecheap API based on the query params.
+func (c *Client) buildURL(ctx context.Context, command, domain string, hosts ...HostRecord) (*url.URL, error) {
+	d, err := c.ResolveDomain(ctx, domain)
+	if err != nil {
+		return nil, err
 	}
 
-	sld := split_domain[0]
-	// Assuming everything else is TLD. This may be a bad assumption.
-	tld := strings.Join(split_domain[1:], ".")
+	params := url.Values{}
+	params.Set("TLD", d.TLD)
+	params.Set("SLD", d.SLD)
 
+	hostTypes := make(map[RecordType]bool)
+	for i, host := range hosts {
+		addToValues(host, i+1, &params)
+		hostTypes[host.RecordType] = true
+	}
+
+	// namecheap rejects MX hosts unless the email type says MX records are
+	// used, and resets the email settings when no email type is sent.
+	switch {
+	case c.emailType != "" && command == "namecheap.domains.dns.setHosts":
+		params.Set("EmailType", c.emailType)
+	case hostTypes[MX]:
+		params.Set("EmailType", "MX")
+	case hostTypes[MXE]:
+		params.Set("EmailType", "MXE")
+	case command == "namecheap.domains.dns.setHosts":
+		if emailType := c.preservedEmailType(domain, hostTypes); emailType != "" {
+			params.Set("EmailType", emailType)
+		}
+	}
+
+	return c.buildCommandURL(command, params), nil
+}
+
+// buildCommandURL builds a URL for a command that isn't tied to a domain.
+// The credentials are added to the given params.
+func (c *Client) buildCommandURL(command string, params url.Values) *url.URL {
 	u := *c.endpointURL
 	q := u.Query()
 	q.Set("ApiUser", c.apiUser)
 	q.Set("ApiKey", c.apiKey)
 	q.Set("UserName", c.username)
-	q.Set("ClientIp", c.clientIP)
+	q.Set("ClientIp", c.currentClientIP())
 	q.Set("Command", command)
-	q.Set("TLD", tld)
-	q.Set("SLD", sld)
 
-	for i, host := range hosts {
-		addToValues(host, i+1, &q)
+	for k, v := range params {
+		q[k] = v
 	}
 
 	u.RawQuery = q.Encode()
 
-	return &u, nil
+	return &u
 }
 
 type apiErrors []apiError
 
-func (e apiErrors) String() string {
-	var errMsg string
-	for i, apiError := range e {
-		errMsg += fmt.Sprintf("Error%d: %s\t", i, apiError.Err)
+// APIError is returned when namecheap responds with errors e.g. when the
+// client IP isn't whitelisted. Number is the number of the first error.
+// When namecheap reports several errors, Message joins all of their messages
+// and Numbers holds the number of each of them in order.
+type APIError struct {
+	Number  int
+	Message string
+	Numbers []int
+}
+
+func (e *APIError) Error() string {
+	if len(e.Numbers) > 1 {
+		return fmt.Sprintf("namecheap api returned errors %v in response. Err: %s", e.Numbers, e.Message)
+	}
+	return fmt.Sprintf("namecheap api returned error %d in response. Err: %s", e.Number, e.Message)
+}
+
+// has reports whether namecheap returned the error number.
+func (e *APIError) has(number int) bool {
+	for _, n := range e.Numbers {
+		if n == number {
+			return true
+		}
+	}
+	return false
+}
+
+// errNumberIPNotWhitelisted is the error number namecheap returns when the
+// client IP isn't whitelisted for API access.
+const errNumberIPNotWhitelisted = 1011150
+
+// ErrIPNotWhitelisted is matched by errors.Is when namecheap rejects a
+// request because the client IP isn't whitelisted for API access.
+var ErrIPNotWhitelisted = errors.New("client IP is not whitelisted")
+
+// ipNotWhitelistedError reports the client IP that namecheap rejected.
+// It wraps the APIError namecheap returned.
+type ipNotWhitelistedError struct {
+	clientIP string
+	err      *APIError
+}
+
+func (e *ipNotWhitelistedError) Error() string {
+	return fmt.Sprintf("client IP: %s is not whitelisted. Whitelist it in the namecheap console under Profile > Tools > API Access. Err: %s", e.clientIP, e.err)
+}
+
+func (e *ipNotWhitelistedError) Is(target error) bool {
+	return target == ErrIPNotWhitelisted
+}
+
+func (e *ipNotWhitelistedError) Unwrap() error {
+	return e.err
+}
+
+// ErrPremiumDNSRequired is matched by errors.Is when namecheap rejects a
+// request because it uses a feature only available with Premium DNS.
+var ErrPremiumDNSRequired = errors.New("feature requires namecheap Premium DNS")
+
+// premiumDNSError suggests upgrading to Premium DNS. It wraps the APIError
+// namecheap returned.
+type premiumDNSError struct {
+	err *APIError
+}
+
+func (e *premiumDNSError) Error() string {
+	return fmt.Sprintf("the request uses a feature that requires namecheap Premium DNS. Upgrade the domain to Premium DNS in the namecheap console or avoid the feature. Err: %s", e.err)
+}
+
+func (e *premiumDNSError) Is(target error) bool {
+	return target == ErrPremiumDNSRequired
+}
+
+func (e *premiumDNSError) Unwrap() error {
+	return e.err
+}
+
+// WithPremiumDNSErrors adds namecheap error numbers that mean a feature
+// requires Premium DNS. namecheap doesn't document a dedicated number so by
+// default only errors whose message mentions Premium DNS are recognized.
+func WithPremiumDNSErrors(numbers ...int) ClientOption {
+	return func(c *Client) error {
+		if c.premiumDNSErrors == nil {
+			c.premiumDNSErrors = make(map[int]bool)
+		}
+		for _, number := range numbers {
+			c.premiumDNSErrors[number] = true
+		}
+		return nil
+	}
+}
+
+// premiumDNSSpelling removes the separators namecheap puts between "Premium"
+// and "DNS" so every spelling of the product name compares equal.
+var premiumDNSSpelling = strings.NewReplacer(" ", "", "-", "", "_", "")
+
+// requiresPremiumDNS reports whether namecheap rejected the request because
+// it needs Premium DNS. Besides the configured numbers, any error whose
+// message names Premium DNS, spelled "Premium DNS", "PremiumDNS" or
+// "Premium-DNS", counts.
+func (c *Client) requiresPremiumDNS(apiErr *APIError) bool {
+	for _, number := range apiErr.Numbers {
+		if c.premiumDNSErrors[number] {
+			return true
+		}
 	}
-	return errMsg
+	message := premiumDNSSpelling.Replace(strings.ToLower(apiErr.Message))
+	return strings.Contains(message, "premiumdns")
+}
+
+// toAPIError converts the errors in a response to an APIError.
+func (e apiErrors) toAPIError() *APIError {
+	apiErr := &APIError{}
+	var messages []string
+	for _, err := range e {
+		// namecheap error numbers are always numeric. Should one not be, it's
+		// reported as 0 rather than hiding the message.
+		number, _ := strconv.Atoi(strings.TrimSpace(err.Number))
+		apiErr.Numbers = append(apiErr.Numbers, number)
+		messages = append(messages, strings.TrimSpace(err.Err))
+	}
+
+	if len(apiErr.Numbers) > 0 {
+		apiErr.Number = apiErr.Numbers[0]
+	}
+	apiErr.Message = strings.Join(messages, "; ")
+
+	return apiErr
 }
 
 // Go XML doesn't support unmarshaling self closing tags e.g. <Errors /> so need to
@@ -427,9 +1663,15 @@ type apiResponse struct {
 }
 
 type commandResponse struct {
-	Type                    string                   `xml:"Type,attr"`
-	DomainDNSSetHostsResult *domainDNSSetHostsResult `xml:"DomainDNSSetHostsResult,omitempty"`
-	DomainDNSGetHostsResult *domainDNSGetHostsResult `xml:"DomainDNSGetHostsResult,omitempty"`
+	Type                      string                     `xml:"Type,attr"`
+	DomainDNSSetHostsResult   *domainDNSSetHostsResult   `xml:"DomainDNSSetHostsResult,omitempty"`
+	DomainDNSGetHostsResult   *domainDNSGetHostsResult   `xml:"DomainDNSGetHostsResult,omitempty"`
+	DomainGetListResult       *domainGetListResult       `xml:"DomainGetListResult,omitempty"`
+	DomainDNSGetListResult    *domainDNSGetListResult    `xml:"DomainDNSGetListResult,omitempty"`
+	DomainDNSSetDefaultResult *domainDNSSetDefaultResult `xml:"DomainDNSSetDefaultResult,omitempty"`
+	DomainDNSSetCustomResult  *domainDNSSetCustomResult  `xml:"DomainDNSSetCustomResult,omitempty"`
+	TLDs                      *tldListResult             `xml:"Tlds,omitempty"`
+	Paging                    *paging                    `xml:"Paging,omitempty"`
 }
 
 type domainDNSSetHostsResult struct {
@@ -440,29 +1682,93 @@ type domainDNSSetHostsResult struct {
 type domainDNSGetHostsResult struct {
 	Domain        string                   `xml:"Domain,attr"`
 	IsUsingOurDNS bool                     `xml:"IsUsingOurDNS,attr"`
+	EmailType     string                   `xml:"EmailType,attr"`
 	Hosts         []getHostsResponseRecord `xml:",any"`
 }
 
-func doRequest(req *http.Request) (*apiResponse, error) {
+type domainGetListResult struct {
+	Domains []getListResponseDomain `xml:"Domain"`
+}
+
+type domainDNSGetListResult struct {
+	Domain        string   `xml:"Domain,attr"`
+	IsUsingOurDNS bool     `xml:"IsUsingOurDNS,attr"`
+	Nameservers   []string `xml:"Nameserver"`
+}
+
+type domainDNSSetDefaultResult struct {
+	Domain  string `xml:"Domain,attr"`
+	Updated bool   `xml:"Updated,attr"`
+}
+
+type domainDNSSetCustomResult struct {
+	Domain  string `xml:"Domain,attr"`
+	Updated bool   `xml:"Updated,attr"`
+}
+
+type paging struct {
+	TotalItems  int `xml:"TotalItems"`
+	CurrentPage int `xml:"CurrentPage"`
+	PageSize    int `xml:"PageSize"`
+}
+
+// statusError is returned when namecheap responds with an unexpected HTTP status.
+type statusError struct {
+	StatusCode int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("namecheap api returned unexpected status: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// sendRequest sends req once and parses the response. The parsed response
+// is returned along with the error when it contains errors so the caller can
+// inspect them.
+func (c *Client) sendRequest(req *http.Request) (*apiResponse, error) {
+	// Every command that makes changes is sent with POST.
+	timeout := c.readTimeout
+	if req.Method == http.MethodPost {
+		timeout = c.writeTimeout
+	}
+
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, &statusError{StatusCode: resp.StatusCode}
 	}
 
+	decoder := xml.NewDecoder(resp.Body)
+	decoder.CharsetReader = c.charsetReader
+
 	var apiResp apiResponse
-	err = xml.Unmarshal(body, &apiResp)
+	err = decoder.Decode(&apiResp)
 	if err != nil {
 		return nil, err
 	}
 
 	if len(apiResp.Errors) > 0 {
-		return nil, fmt.Errorf("namecheap api returned error in response. Err: %s", apiResp.Errors)
+		apiErr := apiResp.Errors.toAPIError()
+		if apiErr.has(errNumberIPNotWhitelisted) {
+			return &apiResp, &ipNotWhitelistedError{clientIP: req.URL.Query().Get("ClientIp"), err: apiErr}
+		}
+		if c.requiresPremiumDNS(apiErr) {
+			return &apiResp, &premiumDNSError{err: apiErr}
+		}
+		if window := c.rateLimitWindow(apiErr); window > 0 {
+			return &apiResp, &RateLimitError{Window: window, Err: apiErr}
+		}
+		return &apiResp, apiErr
 	}
 
 	return &apiResp, nil
@@ -1,11 +1,22 @@
 package namecheap_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -42,6 +53,22 @@ const (
   <ExecutionTime>32.76</ExecutionTime>
 </ApiResponse>`
 
+	modifiedHostsResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<ApiResponse xmlns="http://api.namecheap.com/xml.response" Status="OK">
+  <Errors />
+  <RequestedCommand>namecheap.domains.dns.getHosts</RequestedCommand>
+  <CommandResponse Type="namecheap.domains.dns.getHosts">
+    <DomainDNSGetHostsResult Domain="domain.com" IsUsingOurDNS="true">
+      <Host HostId="12" Name="@" Type="A" Address="1.2.3.4" MXPref="10" TTL="1800" />
+      <Host HostId="14" Name="www" Type="A" Address="122.23.3.7" MXPref="10" TTL="1800" />
+      <Host HostId="15" Name="mail" Type="A" Address="122.23.3.8" MXPref="10" TTL="1800" />
+    </DomainDNSGetHostsResult>
+  </CommandResponse>
+  <Server>SERVER-NAME</Server>
+  <GMTTimeDifference>+5</GMTTimeDifference>
+  <ExecutionTime>32.76</ExecutionTime>
+</ApiResponse>`
+
 	emptyHostsResponse = `<?xml version="1.0" encoding="UTF-8"?>
 <ApiResponse xmlns="http://api.namecheap.com/xml.response" Status="OK">
   <Errors />
@@ -54,6 +81,132 @@ const (
   <ExecutionTime>32.76</ExecutionTime>
 </ApiResponse>`
 
+	getNameserversResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<ApiResponse xmlns="http://api.namecheap.com/xml.response" Status="OK">
+  <Errors />
+  <RequestedCommand>namecheap.domains.dns.getList</RequestedCommand>
+  <CommandResponse Type="namecheap.domains.dns.getList">
+    <DomainDNSGetListResult Domain="domain.com" IsUsingOurDNS="true">
+      <Nameserver>dns1.registrar-servers.com</Nameserver>
+      <Nameserver>dns2.registrar-servers.com</Nameserver>
+    </DomainDNSGetListResult>
+  </CommandResponse>
+  <Server>SERVER-NAME</Server>
+  <GMTTimeDifference>+5</GMTTimeDifference>
+  <ExecutionTime>32.76</ExecutionTime>
+</ApiResponse>`
+
+	getCustomNameserversResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<ApiResponse xmlns="http://api.namecheap.com/xml.response" Status="OK">
+  <Errors />
+  <RequestedCommand>namecheap.domains.dns.getList</RequestedCommand>
+  <CommandResponse Type="namecheap.domains.dns.getList">
+    <DomainDNSGetListResult Domain="domain.com" IsUsingOurDNS="false">
+      <Nameserver>ns1.example.net</Nameserver>
+      <Nameserver>ns2.example.net</Nameserver>
+    </DomainDNSGetListResult>
+  </CommandResponse>
+  <Server>SERVER-NAME</Server>
+  <GMTTimeDifference>+5</GMTTimeDifference>
+  <ExecutionTime>32.76</ExecutionTime>
+</ApiResponse>`
+
+	setDefaultResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<ApiResponse xmlns="http://api.namecheap.com/xml.response" Status="OK">
+  <Errors />
+  <RequestedCommand>namecheap.domains.dns.setDefault</RequestedCommand>
+  <CommandResponse Type="namecheap.domains.dns.setDefault">
+    <DomainDNSSetDefaultResult Domain="domain.co.uk" Updated="true" />
+  </CommandResponse>
+  <Server>SERVER-NAME</Server>
+  <GMTTimeDifference>+5</GMTTimeDifference>
+  <ExecutionTime>32.76</ExecutionTime>
+</ApiResponse>`
+
+	setCustomResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<ApiResponse xmlns="http://api.namecheap.com/xml.response" Status="OK">
+  <Errors />
+  <RequestedCommand>namecheap.domains.dns.setCustom</RequestedCommand>
+  <CommandResponse Type="namecheap.domains.dns.setCustom">
+    <DomainDNSSetCustomResult Domain="domain.com" Updated="true" />
+  </CommandResponse>
+  <Server>SERVER-NAME</Server>
+  <GMTTimeDifference>+5</GMTTimeDifference>
+  <ExecutionTime>32.76</ExecutionTime>
+</ApiResponse>`
+
+	getTLDListResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<ApiResponse xmlns="http://api.namecheap.com/xml.response" Status="OK">
+  <Errors />
+  <RequestedCommand>namecheap.domains.gettldlist</RequestedCommand>
+  <CommandResponse Type="namecheap.domains.getTldList">
+    <Tlds>
+      <Tld Name="com" NonRealTime="false" MinRegisterYears="1" MaxRegisterYears="10" MinRenewYears="1" MaxRenewYears="10" MinTransferYears="1" MaxTransferYears="1" IsApiRegisterable="true" IsApiRenewable="true" IsApiTransferable="true" IsEppRequired="true" IsDisableModContact="false" IsDisableWGAllot="false" IsIncludeInExtendedSearchOnly="false" SequenceNumber="10" Type="GTLD" SubType="" IsSupportsIDN="true" Category="P" SupportsRegistrarLock="true" AddGracePeriodDays="5" WhoisVerification="false" ProviderApiDelete="true" TldState="" SearchGroup="" Registry="">Most recognized top level domain<Categories><TldCategory Name="popular" SequenceNumber="10" /></Categories></Tld>
+      <Tld Name="co.uk" NonRealTime="false" MinRegisterYears="1" MaxRegisterYears="10" MinRenewYears="1" MaxRenewYears="10" MinTransferYears="0" MaxTransferYears="0" IsApiRegisterable="false" IsApiRenewable="true" IsApiTransferable="false" IsEppRequired="false" IsDisableModContact="false" IsDisableWGAllot="false" IsIncludeInExtendedSearchOnly="false" SequenceNumber="20" Type="CCTLD" SubType="" IsSupportsIDN="false" Category="A" SupportsRegistrarLock="false" AddGracePeriodDays="0" WhoisVerification="false" ProviderApiDelete="false" TldState="" SearchGroup="" Registry="">United Kingdom<Categories><TldCategory Name="country" SequenceNumber="20" /></Categories></Tld>
+    </Tlds>
+  </CommandResponse>
+  <Server>SERVER-NAME</Server>
+  <GMTTimeDifference>+5</GMTTimeDifference>
+  <ExecutionTime>32.76</ExecutionTime>
+</ApiResponse>`
+
+	getListResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<ApiResponse xmlns="http://api.namecheap.com/xml.response" Status="OK">
+  <Errors />
+  <RequestedCommand>namecheap.domains.getList</RequestedCommand>
+  <CommandResponse Type="namecheap.domains.getList">
+    <DomainGetListResult>
+      <Domain ID="127" Name="domain1.com" User="owner" Created="02/15/2016" Expires="02/15/2022" IsExpired="false" IsLocked="false" AutoRenew="false" WhoisGuard="ENABLED" />
+      <Domain ID="381" Name="domain2.net" User="owner" Created="04/28/2016" Expires="04/28/2020" IsExpired="true" IsLocked="false" AutoRenew="true" IsPremium="true" WhoisGuard="NOTPRESENT" />
+    </DomainGetListResult>
+    <Paging>
+      <TotalItems>2</TotalItems>
+      <CurrentPage>1</CurrentPage>
+      <PageSize>20</PageSize>
+    </Paging>
+  </CommandResponse>
+  <Server>SERVER-NAME</Server>
+  <GMTTimeDifference>+5</GMTTimeDifference>
+  <ExecutionTime>32.76</ExecutionTime>
+</ApiResponse>`
+
+	dnsSetupFailedResponse = `<?xml version="1.0" encoding="utf-8"?>
+<ApiResponse Status="ERROR" xmlns="http://api.namecheap.com/xml.response">
+  <Errors>
+    <Error Number="2030166">DNS setup failed</Error>
+  </Errors>
+  <Warnings />
+  <RequestedCommand />
+  <Server>TEST111</Server>
+  <GMTTimeDifference>--1:00</GMTTimeDifference>
+  <ExecutionTime>0</ExecutionTime>
+</ApiResponse>`
+
+	multipleErrorsResponse = `<?xml version="1.0" encoding="utf-8"?>
+<ApiResponse Status="ERROR" xmlns="http://api.namecheap.com/xml.response">
+  <Errors>
+    <Error Number="1011150">Invalid request IP: 1.2.3.4</Error>
+    <Error Number="1010102">Parameter APIKey is missing</Error>
+  </Errors>
+  <Warnings />
+  <RequestedCommand />
+  <Server>TEST111</Server>
+  <GMTTimeDifference>--1:00</GMTTimeDifference>
+  <ExecutionTime>0</ExecutionTime>
+</ApiResponse>`
+
+	ipNotWhitelistedResponse = `<?xml version="1.0" encoding="utf-8"?>
+<ApiResponse Status="ERROR" xmlns="http://api.namecheap.com/xml.response">
+  <Errors>
+    <Error Number="1011150">Invalid request IP</Error>
+  </Errors>
+  <Warnings />
+  <RequestedCommand />
+  <Server>TEST111</Server>
+  <GMTTimeDifference>--1:00</GMTTimeDifference>
+  <ExecutionTime>0</ExecutionTime>
+</ApiResponse>`
+
 	errorResponse = `<?xml version="1.0" encoding="utf-8"?>
 <ApiResponse Status="ERROR" xmlns="http://api.namecheap.com/xml.response">
   <Errors>
@@ -502,22 +655,117 @@ func TestGetHostsError(t *testing.T) {
 	if err == nil {
 		t.Fatal("Expected error but got nil")
 	}
+
+	var apiErr *namecheap.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected APIError. Got: %T", err)
+	}
+
+	expected := &namecheap.APIError{Number: 1010102, Message: "Parameter APIKey is missing", Numbers: []int{1010102}}
+	if diff := cmp.Diff(expected, apiErr); diff != "" {
+		t.Fatalf("APIError not equal to expected. Diff: %s", diff)
+	}
+}
+
+func TestGetHostsMultipleErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(multipleErrorsResponse))
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	_, err = c.GetHosts(context.TODO(), "any.domain")
+
+	var apiErr *namecheap.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected APIError. Got: %T", err)
+	}
+
+	expected := &namecheap.APIError{
+		Number:  1011150,
+		Message: "Invalid request IP: 1.2.3.4; Parameter APIKey is missing",
+		Numbers: []int{1011150, 1010102},
+	}
+	if diff := cmp.Diff(expected, apiErr); diff != "" {
+		t.Fatalf("APIError not equal to expected. Diff: %s", diff)
+	}
 }
 
 func TestBadURL(t *testing.T) {
-	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint("any"), namecheap.WithClientIP("localhost"))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("Unexpected request: %s", r.URL)
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"))
 	if err != nil {
 		t.Fatalf("Error creating NewClient. Err: %s", err)
 	}
 
-	_, err = c.GetHosts(context.TODO(), "com")
-	if err == nil {
-		t.Fatal("Expected error but got nil")
+	cases := map[string]struct {
+		domain string
+		err    string
+	}{
+		"empty":      {domain: "", err: "domain is empty"},
+		"root":       {domain: ".", err: "domain is empty"},
+		"bare TLD":   {domain: "com", err: "domain: com is not a valid domain"},
+		"bare FQDN":  {domain: "com.", err: "domain: com is not a valid domain"},
+		"empty SLD":  {domain: ".com", err: "Expected no empty labels"},
+		"empty part": {domain: "example..com", err: "Expected no empty labels"},
+		"IPv4":       {domain: "192.0.2.1", err: "domain: 192.0.2.1 is an IP address"},
+		"IPv6":       {domain: "2001:db8::1", err: "domain: 2001:db8::1 is an IP address"},
 	}
 
-	_, err = c.GetHosts(context.TODO(), "")
-	if err == nil {
-		t.Fatal("Expected error but got nil")
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := c.GetHosts(context.TODO(), tc.domain)
+			if err == nil {
+				t.Fatal("Expected error but got nil")
+			}
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("Expected error containing %q. Got: %s", tc.err, err)
+			}
+
+			_, err = c.SetHosts(context.TODO(), tc.domain, []namecheap.HostRecord{{Name: "@", RecordType: namecheap.A, Address: "1.2.3.4"}})
+			if err == nil {
+				t.Fatal("Expected error but got nil")
+			}
+		})
+	}
+}
+
+func TestEndpointValidation(t *testing.T) {
+	cases := map[string]struct {
+		endpoint string
+		valid    bool
+	}{
+		"https":       {endpoint: "https://api.namecheap.com/xml.response", valid: true},
+		"http":        {endpoint: "http://localhost:8080", valid: true},
+		"no scheme":   {endpoint: "any"},
+		"bad scheme":  {endpoint: "ftp://api.namecheap.com/xml.response"},
+		"no host":     {endpoint: "https:///xml.response"},
+		"unparseable": {endpoint: "https://api.namecheap.com:port"},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(tc.endpoint), namecheap.WithClientIP("localhost"), namecheap.WithEndpointValidation())
+			if tc.valid && err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("Expected error but got nil")
+			}
+		})
+	}
+
+	// Without validation a malformed endpoint only fails on the first request.
+	if _, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint("any"), namecheap.WithClientIP("localhost")); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
 	}
 }
 
@@ -540,6 +788,183 @@ func TestAutoDiscoverIP(t *testing.T) {
 	c.GetHosts(context.TODO(), "any.domain")
 }
 
+func TestAutoDiscoverIPEndpoints(t *testing.T) {
+	discovery := func(response string) *httptest.Server {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(response))
+		}))
+		t.Cleanup(ts.Close)
+		return ts
+	}
+	ipv4 := discovery("203.0.113.7\n")
+	ipv6 := discovery("2001:db8::7\n")
+	invalid := discovery("<html>rate limited</html>")
+
+	cases := map[string]struct {
+		options    []namecheap.ClientOption
+		expectedIP string
+	}{
+		"ipv6 only": {
+			options:    []namecheap.ClientOption{namecheap.AutoDiscoverPublicIP(ipv6.URL)},
+			expectedIP: "2001:db8::7",
+		},
+		"skips invalid response": {
+			options:    []namecheap.ClientOption{namecheap.AutoDiscoverPublicIP(invalid.URL, ipv4.URL)},
+			expectedIP: "203.0.113.7",
+		},
+		"prefers ipv4 by default": {
+			options:    []namecheap.ClientOption{namecheap.AutoDiscoverPublicIP(ipv6.URL, ipv4.URL)},
+			expectedIP: "203.0.113.7",
+		},
+		"prefers ipv6": {
+			options:    []namecheap.ClientOption{namecheap.AutoDiscoverPublicIP(ipv4.URL, ipv6.URL), namecheap.WithDiscoveryPreferIPv6()},
+			expectedIP: "2001:db8::7",
+		},
+		"falls back to ipv4": {
+			options:    []namecheap.ClientOption{namecheap.AutoDiscoverPublicIP(invalid.URL, ipv4.URL), namecheap.WithDiscoveryPreferIPv6()},
+			expectedIP: "203.0.113.7",
+		},
+		"falls back to static ip": {
+			options:    []namecheap.ClientOption{namecheap.AutoDiscoverPublicIP(invalid.URL), namecheap.WithFallbackClientIP("198.51.100.1")},
+			expectedIP: "198.51.100.1",
+		},
+		"ignores fallback when discovered": {
+			options:    []namecheap.ClientOption{namecheap.AutoDiscoverPublicIP(ipv4.URL), namecheap.WithFallbackClientIP("198.51.100.1")},
+			expectedIP: "203.0.113.7",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.URL.Query().Get("ClientIp"); got != tc.expectedIP {
+					t.Errorf("Expected ClientIp: %s\tGot: %s", tc.expectedIP, got)
+				}
+				w.Write([]byte(getHostsResponse))
+			}))
+			t.Cleanup(ts.Close)
+
+			c, err := namecheap.NewClient("testAPIKey", "testUser", append(tc.options, namecheap.WithEndpoint(ts.URL))...)
+			if err != nil {
+				t.Fatalf("Error creating NewClient. Err: %s", err)
+			}
+
+			if _, err := c.GetHosts(context.TODO(), "domain.com"); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+		})
+	}
+
+	t.Run("no valid response", func(t *testing.T) {
+		_, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.AutoDiscoverPublicIP(invalid.URL))
+		if err == nil {
+			t.Fatal("Expected error but got nil")
+		}
+	})
+}
+
+func TestAutoDiscoverIPCaching(t *testing.T) {
+	var mu sync.Mutex
+	var discoveries int
+	discoveryFails := false
+	discovery := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		discoveries++
+		if discoveryFails {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, "127.0.0.%d", discoveries)
+	}))
+	t.Cleanup(discovery.Close)
+
+	var sentIPs []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		sentIPs = append(sentIPs, r.URL.Query().Get("ClientIp"))
+		mu.Unlock()
+		w.Write([]byte(getHostsResponse))
+	}))
+	t.Cleanup(ts.Close)
+
+	reset := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		discoveries = 0
+		discoveryFails = false
+		sentIPs = nil
+	}
+
+	t.Run("discovered once", func(t *testing.T) {
+		reset()
+		c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.AutoDiscoverPublicIP(), namecheap.WithDiscoveryAddress(discovery.URL), namecheap.WithEndpoint(ts.URL))
+		if err != nil {
+			t.Fatalf("Error creating NewClient. Err: %s", err)
+		}
+
+		for i := 0; i < 3; i++ {
+			if _, err := c.GetHosts(context.TODO(), "domain.com"); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+		}
+
+		if discoveries != 1 {
+			t.Fatalf("Expected 1 discovery. Got: %d", discoveries)
+		}
+		if diff := cmp.Diff([]string{"127.0.0.1", "127.0.0.1", "127.0.0.1"}, sentIPs); diff != "" {
+			t.Fatalf("Sent IPs not equal to expected. Diff: %s", diff)
+		}
+	})
+
+	t.Run("refreshed", func(t *testing.T) {
+		reset()
+		c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.AutoDiscoverPublicIP(), namecheap.WithDiscoveryAddress(discovery.URL), namecheap.WithEndpoint(ts.URL), namecheap.WithIPDiscoveryRefresh(50*time.Millisecond))
+		if err != nil {
+			t.Fatalf("Error creating NewClient. Err: %s", err)
+		}
+
+		if _, err := c.GetHosts(context.TODO(), "domain.com"); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if _, err := c.GetHosts(context.TODO(), "domain.com"); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+
+		if discoveries != 2 {
+			t.Fatalf("Expected 2 discoveries. Got: %d", discoveries)
+		}
+		if diff := cmp.Diff([]string{"127.0.0.1", "127.0.0.2"}, sentIPs); diff != "" {
+			t.Fatalf("Sent IPs not equal to expected. Diff: %s", diff)
+		}
+	})
+
+	t.Run("refresh fails", func(t *testing.T) {
+		reset()
+		c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.AutoDiscoverPublicIP(), namecheap.WithDiscoveryAddress(discovery.URL), namecheap.WithEndpoint(ts.URL), namecheap.WithIPDiscoveryRefresh(50*time.Millisecond))
+		if err != nil {
+			t.Fatalf("Error creating NewClient. Err: %s", err)
+		}
+
+		mu.Lock()
+		discoveryFails = true
+		mu.Unlock()
+		time.Sleep(100 * time.Millisecond)
+
+		if _, err := c.GetHosts(context.TODO(), "domain.com"); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+
+		if discoveries != 2 {
+			t.Fatalf("Expected 2 discoveries. Got: %d", discoveries)
+		}
+		if diff := cmp.Diff([]string{"127.0.0.1"}, sentIPs); diff != "" {
+			t.Fatalf("Sent IPs not equal to expected. Diff: %s", diff)
+		}
+	})
+}
+
 func TestDeleteHostsWithExisting(t *testing.T) {
 	expectedValues := map[string]string{
 		"ApiUser":     "testUser",
@@ -638,3 +1063,1820 @@ func TestDeleteHostsNoExisting(t *testing.T) {
 		t.Fatalf("Expected 2 host. Got: %v", len(hosts))
 	}
 }
+
+func TestDeleteHostsNoExistingNoopError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			t.Fatal("Unexpected setHosts request")
+		}
+		w.Write([]byte(getHostsResponse))
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"), namecheap.WithNoopDeleteError())
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	hostsToDelete := []namecheap.HostRecord{
+		{
+			HostID: "nonexistanthost",
+		},
+	}
+	hosts, err := c.DeleteHosts(context.TODO(), "domain.com", hostsToDelete)
+	if !errors.Is(err, namecheap.ErrNothingDeleted) {
+		t.Fatalf("Expected ErrNothingDeleted. Got: %v", err)
+	}
+
+	if len(hosts) != 2 {
+		t.Fatalf("Expected 2 host. Got: %v", len(hosts))
+	}
+}
+
+func TestGetDomains(t *testing.T) {
+	expectedValues := map[string]string{
+		"ApiUser":  "testUser",
+		"ApiKey":   "testAPIKey",
+		"UserName": "testUser",
+		"ClientIp": "localhost",
+		"Command":  "namecheap.domains.getList",
+		"Page":     "1",
+		"PageSize": "100",
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ensureQueryParams(t, r, toURLValues(expectedValues))
+		w.Write([]byte(getListResponse))
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	domains, err := c.GetDomains(context.TODO())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expectedDomains := []namecheap.DomainInfo{
+		{
+			ID:         "127",
+			Name:       "domain1.com",
+			User:       "owner",
+			Created:    time.Date(2016, time.February, 15, 0, 0, 0, 0, time.UTC),
+			Expires:    time.Date(2022, time.February, 15, 0, 0, 0, 0, time.UTC),
+			WhoisGuard: "ENABLED",
+		},
+		{
+			ID:         "381",
+			Name:       "domain2.net",
+			User:       "owner",
+			Created:    time.Date(2016, time.April, 28, 0, 0, 0, 0, time.UTC),
+			Expires:    time.Date(2020, time.April, 28, 0, 0, 0, 0, time.UTC),
+			IsExpired:  true,
+			AutoRenew:  true,
+			IsPremium:  true,
+			WhoisGuard: "NOTPRESENT",
+		},
+	}
+
+	if diff := cmp.Diff(expectedDomains, domains); diff != "" {
+		t.Fatalf("Domains and expected domains are not equal. Diff: %s", diff)
+	}
+}
+
+func TestSetHostsNameTooLong(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			t.Fatal("Unexpected setHosts request with invalid host name")
+		case http.MethodGet:
+			w.Write([]byte(emptyHostsResponse))
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	longLabel := strings.Repeat("a", 64)
+	// 245 characters which becomes 256 once ".domain.com" is added.
+	longName := strings.Repeat(strings.Repeat("b", 63)+".", 3) + strings.Repeat("b", 53)
+
+	cases := map[string]struct {
+		name        string
+		expectedErr string
+	}{
+		"64 character label": {
+			name:        longLabel,
+			expectedErr: "is 64 characters long. Labels must be at most 63 characters",
+		},
+		"256 character name": {
+			name:        longName,
+			expectedErr: "is 256 characters long. Names must be at most 255 characters",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			hosts := []namecheap.HostRecord{
+				{
+					Name:       tc.name,
+					RecordType: namecheap.A,
+					Address:    "127.0.0.1",
+				},
+			}
+
+			_, err := c.AddHosts(context.TODO(), "domain.com", hosts)
+			if err == nil {
+				t.Fatal("Expected error but got nil")
+			}
+
+			if !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Fatalf("Expected error to contain: %q. Got: %q", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestSetHostsUnchangedSkipsWrite(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			t.Fatal("Unexpected setHosts request when hosts are unchanged")
+		case http.MethodGet:
+			w.Write([]byte(getHostsResponse))
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	hosts := []namecheap.HostRecord{
+		{
+			Name:       "www",
+			HostID:     "14",
+			RecordType: namecheap.A,
+			Address:    "122.23.3.7",
+			MXPref:     "10",
+			TTL:        1800,
+		},
+	}
+
+	updatedHosts, err := c.SetHosts(context.TODO(), "domain.com", hosts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(updatedHosts) != 2 {
+		t.Fatalf("Expected 2 hosts. Got: %v", len(updatedHosts))
+	}
+}
+
+func TestDeleteHostsNoMatchSkipsWrite(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			t.Fatal("Unexpected setHosts request when no hosts are deleted")
+		case http.MethodGet:
+			w.Write([]byte(getHostsResponse))
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	hostsToDelete := []namecheap.HostRecord{
+		{
+			HostID: "nonexistanthost",
+		},
+	}
+	if _, err := c.DeleteHosts(context.TODO(), "domain.com", hostsToDelete); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+}
+
+func TestAddHostsUsesCachedHosts(t *testing.T) {
+	var getHostsRequests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			w.Write([]byte(setHostsResponse))
+		case http.MethodGet:
+			getHostsRequests++
+			w.Write([]byte(getHostsResponse))
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	cache := namecheap.NewHostsCache(time.Minute)
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"), namecheap.WithHostsCache(cache))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	if _, err := c.GetHosts(context.TODO(), "domain.com"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	newHosts := []namecheap.HostRecord{
+		{
+			Name:       "third_host",
+			RecordType: namecheap.A,
+		},
+	}
+	if _, err := c.AddHosts(context.TODO(), "domain.com.", newHosts); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if getHostsRequests != 1 {
+		t.Fatalf("Expected the cached hosts to be used. Got: %d getHosts requests", getHostsRequests)
+	}
+
+	// Setting hosts invalidates the cache so the hosts are fetched again.
+	if _, err := c.AddHosts(context.TODO(), "domain.com", newHosts); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if getHostsRequests != 2 {
+		t.Fatalf("Expected hosts to be fetched after the cache was invalidated. Got: %d getHosts requests", getHostsRequests)
+	}
+}
+
+func TestSetHostsInvalidatesCacheFilledDuringWrite(t *testing.T) {
+	var getHostsRequests atomic.Int32
+	posting := make(chan struct{})
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			close(posting)
+			<-release
+			w.Write([]byte(setHostsResponse))
+		case http.MethodGet:
+			getHostsRequests.Add(1)
+			w.Write([]byte(getHostsResponse))
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	cache := namecheap.NewHostsCache(time.Minute)
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"), namecheap.WithHostsCache(cache))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := c.ReplaceHosts(context.TODO(), "domain.com", []namecheap.HostRecord{{Name: "@", RecordType: namecheap.A, Address: "5.6.7.8"}})
+		done <- err
+	}()
+
+	// Hosts fetched while the write is in flight are from before it.
+	<-posting
+	if _, err := c.GetHosts(context.TODO(), "domain.com"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if _, err := c.GetHosts(context.TODO(), "domain.com"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if got := getHostsRequests.Load(); got != 2 {
+		t.Fatalf("Expected the hosts fetched during the write not to be cached. Got: %d getHosts requests", got)
+	}
+}
+
+func TestSetHostsMXSendsEmailType(t *testing.T) {
+	expected := map[string]string{
+		"ApiUser":     "testUser",
+		"ApiKey":      "testAPIKey",
+		"UserName":    "testUser",
+		"ClientIp":    "localhost",
+		"Command":     "namecheap.domains.dns.setHosts",
+		"TLD":         "com",
+		"SLD":         "domain",
+		"EmailType":   "MX",
+		"HostName1":   "@",
+		"RecordType1": string(namecheap.MX),
+		"Address1":    "mail1.domain.com",
+		"MXPref1":     "10",
+		"HostName2":   "@",
+		"RecordType2": string(namecheap.MX),
+		"Address2":    "mail2.domain.com",
+		"MXPref2":     "20",
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			ensureQueryParams(t, r, toURLValues(expected))
+			w.Write([]byte(setHostsResponse))
+		case http.MethodGet:
+			w.Write([]byte(emptyHostsResponse))
+		}
+	}))
+	t.Cleanup(ts.Close)
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	hosts := []namecheap.HostRecord{
+		{
+			Name:       "@",
+			RecordType: namecheap.MX,
+			Address:    "mail1.domain.com",
+			MXPref:     "10",
+		},
+		{
+			Name:       "@",
+			RecordType: namecheap.MX,
+			Address:    "mail2.domain.com",
+			MXPref:     "20",
+		},
+	}
+
+	if _, err := c.SetHosts(context.TODO(), "domain.com", hosts); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+}
+
+func TestReplaceHostsWaitsForConsistency(t *testing.T) {
+	var written bool
+	var getHostsAfterWrite int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			written = true
+			w.Write([]byte(setHostsResponse))
+		case http.MethodGet:
+			if written {
+				getHostsAfterWrite++
+			}
+
+			// The written hosts only show up on the third read.
+			if getHostsAfterWrite < 3 {
+				w.Write([]byte(emptyHostsResponse))
+				return
+			}
+			w.Write([]byte(getHostsResponse))
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"), namecheap.WithConsistencyCheck(time.Second, time.Millisecond))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	hosts := []namecheap.HostRecord{
+		{
+			Name:       "www",
+			RecordType: namecheap.A,
+			Address:    "122.23.3.7",
+		},
+		{
+			Name:       "@",
+			RecordType: namecheap.A,
+			Address:    "1.2.3.4",
+		},
+	}
+
+	storedHosts, err := c.ReplaceHosts(context.TODO(), "domain.com", hosts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if getHostsAfterWrite != 3 {
+		t.Fatalf("Expected 3 getHosts requests after writing. Got: %d", getHostsAfterWrite)
+	}
+
+	for _, host := range storedHosts {
+		if host.HostID == "" {
+			t.Fatal("Expected stored hosts to have their HostID set")
+		}
+	}
+}
+
+func TestReplaceHostsWarnsAboutAdjustedTTLs(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			w.Write([]byte(setHostsResponse))
+		case http.MethodGet:
+			// Both hosts are stored with a TTL of 1800.
+			w.Write([]byte(getHostsResponse))
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	var warnings []string
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"),
+		namecheap.WithConsistencyCheck(time.Second, time.Millisecond),
+		namecheap.WithWarnings(func(warning string) { warnings = append(warnings, warning) }))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	hosts := []namecheap.HostRecord{
+		{Name: "@", RecordType: namecheap.A, Address: "1.2.3.4", TTL: 60},
+		{Name: "www", RecordType: namecheap.A, Address: "122.23.3.7", TTL: 1800},
+	}
+	if _, err := c.ReplaceHosts(context.TODO(), "domain.com", hosts); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expected := []string{"A host: @ of domain: domain.com was written with a TTL of 60 seconds but namecheap stored 1800 seconds"}
+	if diff := cmp.Diff(expected, warnings); diff != "" {
+		t.Fatalf("Warnings not equal to expected. Diff: %s", diff)
+	}
+}
+
+func TestReplaceHostsConsistencyTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			w.Write([]byte(setHostsResponse))
+		case http.MethodGet:
+			w.Write([]byte(emptyHostsResponse))
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"), namecheap.WithConsistencyCheck(50*time.Millisecond, 10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	hosts := []namecheap.HostRecord{
+		{
+			Name:       "@",
+			RecordType: namecheap.A,
+			Address:    "1.2.3.4",
+		},
+	}
+
+	if _, err := c.ReplaceHosts(context.TODO(), "domain.com", hosts); err == nil {
+		t.Fatal("Expected error but got nil")
+	}
+}
+
+func TestAddHostsOperationBudget(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Each request uses up more than half of the budget.
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(60 * time.Millisecond):
+		}
+
+		switch r.Method {
+		case http.MethodPost:
+			w.Write([]byte(setHostsResponse))
+		case http.MethodGet:
+			w.Write([]byte(getHostsResponse))
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	budget := 100 * time.Millisecond
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"), namecheap.WithOperationBudget(budget))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	// A single request fits in the budget.
+	if _, err := c.GetHosts(context.TODO(), "domain.com"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	newHosts := []namecheap.HostRecord{
+		{
+			Name:       "third_host",
+			RecordType: namecheap.A,
+		},
+	}
+
+	start := time.Now()
+	_, err = c.AddHosts(context.TODO(), "domain.com", newHosts)
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded error. Got: %v", err)
+	}
+
+	if elapsed > budget+50*time.Millisecond {
+		t.Fatalf("Operation took %s which exceeds the budget of %s", elapsed, budget)
+	}
+}
+
+func TestGetDomainsPaging(t *testing.T) {
+	pageResponse := func(page int, names ...string) string {
+		var domains strings.Builder
+		for _, name := range names {
+			domains.WriteString(`<Domain ID="1" Name="` + name + `" User="owner" Created="02/15/2016" Expires="02/15/2030" IsExpired="false" IsLocked="false" AutoRenew="false" WhoisGuard="ENABLED" />`)
+		}
+		return `<?xml version="1.0" encoding="UTF-8"?>
+<ApiResponse xmlns="http://api.namecheap.com/xml.response" Status="OK">
+  <Errors />
+  <RequestedCommand>namecheap.domains.getList</RequestedCommand>
+  <CommandResponse Type="namecheap.domains.getList">
+    <DomainGetListResult>` + domains.String() + `</DomainGetListResult>
+    <Paging>
+      <TotalItems>3</TotalItems>
+      <CurrentPage>` + strconv.Itoa(page) + `</CurrentPage>
+      <PageSize>2</PageSize>
+    </Paging>
+  </CommandResponse>
+</ApiResponse>`
+	}
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch page := r.URL.Query().Get("Page"); page {
+		case "1":
+			w.Write([]byte(pageResponse(1, "domain1.com", "domain2.com")))
+		case "2":
+			w.Write([]byte(pageResponse(2, "domain3.com")))
+		default:
+			t.Fatalf("Unexpected request for page: %s", page)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	domains, err := c.GetDomains(context.TODO())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	var names []string
+	for _, domain := range domains {
+		names = append(names, domain.Name)
+	}
+
+	if diff := cmp.Diff([]string{"domain1.com", "domain2.com", "domain3.com"}, names); diff != "" {
+		t.Fatalf("Domains not equal to expected domains. Diff: %s", diff)
+	}
+
+	if requests != 2 {
+		t.Fatalf("Expected 2 requests. Got: %d", requests)
+	}
+}
+
+func TestSetHostsRetriesTransientErrors(t *testing.T) {
+	var setHostsRequests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			setHostsRequests++
+			switch setHostsRequests {
+			case 1:
+				w.WriteHeader(http.StatusInternalServerError)
+			case 2:
+				w.Write([]byte(dnsSetupFailedResponse))
+			default:
+				w.Write([]byte(setHostsResponse))
+			}
+		case http.MethodGet:
+			w.Write([]byte(emptyHostsResponse))
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"), namecheap.WithRetry(3, time.Millisecond))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	hosts := []namecheap.HostRecord{
+		{
+			Name:       "@",
+			RecordType: namecheap.A,
+			Address:    "1.2.3.4",
+		},
+	}
+	if _, err := c.SetHosts(context.TODO(), "domain.com", hosts); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if setHostsRequests != 3 {
+		t.Fatalf("Expected 3 setHosts requests. Got: %d", setHostsRequests)
+	}
+}
+
+func TestRetryLimits(t *testing.T) {
+	cases := map[string]struct {
+		response         func(w http.ResponseWriter)
+		options          []namecheap.ClientOption
+		expectedRequests int
+	}{
+		"gives up after max attempts": {
+			response: func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusBadGateway)
+			},
+			expectedRequests: 3,
+		},
+		"does not retry non-transient errors": {
+			response: func(w http.ResponseWriter) {
+				w.Write([]byte(errorResponse))
+			},
+			expectedRequests: 1,
+		},
+		"retries configured errors": {
+			response: func(w http.ResponseWriter) {
+				w.Write([]byte(errorResponse))
+			},
+			options:          []namecheap.ClientOption{namecheap.WithRetryableErrors(1010102)},
+			expectedRequests: 3,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var requests int
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				tc.response(w)
+			}))
+			t.Cleanup(ts.Close)
+
+			options := append([]namecheap.ClientOption{namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"), namecheap.WithRetry(3, time.Millisecond)}, tc.options...)
+			c, err := namecheap.NewClient("testAPIKey", "testUser", options...)
+			if err != nil {
+				t.Fatalf("Error creating NewClient. Err: %s", err)
+			}
+
+			if _, err := c.GetHosts(context.TODO(), "domain.com"); err == nil {
+				t.Fatal("Expected error but got nil")
+			}
+
+			if requests != tc.expectedRequests {
+				t.Fatalf("Expected %d requests. Got: %d", tc.expectedRequests, requests)
+			}
+		})
+	}
+}
+
+func TestRetryHonorsContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"), namecheap.WithRetry(5, time.Hour))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := c.GetHosts(ctx, "domain.com"); err == nil {
+		t.Fatal("Expected error but got nil")
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Retry did not honor the context. Took: %s", elapsed)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	var mu sync.Mutex
+	var requestTimes []time.Time
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requestTimes = append(requestTimes, time.Now())
+		mu.Unlock()
+		w.Write([]byte(getHostsResponse))
+	}))
+	t.Cleanup(ts.Close)
+
+	// One request every 50ms.
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"), namecheap.WithRateLimit(1200))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.GetHosts(context.TODO(), "domain.com"); err != nil {
+				t.Errorf("Unexpected error: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(requestTimes, func(i, j int) bool { return requestTimes[i].Before(requestTimes[j]) })
+	for i := 1; i < len(requestTimes); i++ {
+		// Allow for some scheduling jitter.
+		if gap := requestTimes[i].Sub(requestTimes[i-1]); gap < 40*time.Millisecond {
+			t.Fatalf("Requests %d and %d were only %s apart", i-1, i, gap)
+		}
+	}
+}
+
+func TestRateLimitHonorsContext(t *testing.T) {
+	limiter := namecheap.NewRateLimiter(1)
+	if err := limiter.Wait(context.TODO()); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded error. Got: %v", err)
+	}
+}
+
+func TestRateLimitReleasesCancelledWait(t *testing.T) {
+	limiter := namecheap.NewRateLimiter(60)
+	if err := limiter.Wait(context.TODO()); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err := limiter.Wait(ctx)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected deadline exceeded error. Got: %v", err)
+		}
+	}
+
+	// Had the cancelled waits kept their slots, the next one would be 4
+	// seconds after the first wait.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := limiter.Wait(ctx); err != nil {
+		t.Fatalf("Expected the cancelled slots to be released. Got: %v", err)
+	}
+}
+
+func TestSetHostsRequestDump(t *testing.T) {
+	var sent url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			sent = r.URL.Query()
+			w.Write([]byte(setHostsResponse))
+		case http.MethodGet:
+			w.Write([]byte(emptyHostsResponse))
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	var dump bytes.Buffer
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"), namecheap.WithRequestDump(&dump))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	hosts := []namecheap.HostRecord{
+		{
+			Name:       "@",
+			RecordType: namecheap.TXT,
+			Address:    "v=spf1 include:example.com ~all",
+			TTL:        300,
+		},
+	}
+	if _, err := c.SetHosts(context.TODO(), "domain.com", hosts); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if strings.Contains(dump.String(), "testAPIKey") {
+		t.Fatalf("Dump contains the API key: %s", dump.String())
+	}
+
+	dumped, err := url.ParseQuery(strings.TrimSpace(dump.String()))
+	if err != nil {
+		t.Fatalf("Unable to parse dump. Err: %s", err)
+	}
+
+	if got := dumped.Get("ApiKey"); got != "REDACTED" {
+		t.Fatalf("Expected redacted ApiKey. Got: %s", got)
+	}
+
+	dumped.Del("ApiKey")
+	sent.Del("ApiKey")
+	if diff := cmp.Diff(sent, dumped); diff != "" {
+		t.Fatalf("Dump does not match the sent request. Diff: %s", diff)
+	}
+}
+
+func TestGetHostsCharsetReader(t *testing.T) {
+	// café encoded in ISO-8859-1 as a proxy might transcode it.
+	response := strings.Replace(getHostsResponse, `encoding="UTF-8"`, `encoding="ISO-8859-1"`, 1)
+	response = strings.Replace(response, `Name="www" Type="A" Address="122.23.3.7"`, "Name=\"www\" Type=\"TXT\" Address=\"caf\xe9\"", 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(ts.Close)
+
+	latin1Reader := func(charset string, input io.Reader) (io.Reader, error) {
+		if !strings.EqualFold(charset, "ISO-8859-1") {
+			return nil, fmt.Errorf("unsupported charset: %s", charset)
+		}
+		b, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		return strings.NewReader(string(runes)), nil
+	}
+
+	t.Run("default rejects other charsets", func(t *testing.T) {
+		c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"))
+		if err != nil {
+			t.Fatalf("Error creating NewClient. Err: %s", err)
+		}
+
+		if _, err := c.GetHosts(context.TODO(), "domain.com"); err == nil {
+			t.Fatal("Expected error but got nil")
+		}
+	})
+
+	t.Run("charset reader", func(t *testing.T) {
+		c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"), namecheap.WithXMLDecoder(latin1Reader))
+		if err != nil {
+			t.Fatalf("Error creating NewClient. Err: %s", err)
+		}
+
+		hosts, err := c.GetHosts(context.TODO(), "domain.com")
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+
+		if len(hosts) != 2 || hosts[1].Address != "café" {
+			t.Fatalf("Expected TXT host with address café. Got: %+v", hosts)
+		}
+	})
+}
+
+func TestGetNameservers(t *testing.T) {
+	expectedValues := map[string]string{
+		"ApiUser":  "testUser",
+		"ApiKey":   "testAPIKey",
+		"UserName": "testUser",
+		"ClientIp": "localhost",
+		"Command":  "namecheap.domains.dns.getList",
+		"SLD":      "domain",
+		"TLD":      "com",
+	}
+
+	cases := map[string]struct {
+		response            string
+		expected            []string
+		expectedUsingOurDNS bool
+	}{
+		"namecheap": {
+			response:            getNameserversResponse,
+			expected:            []string{"dns1.registrar-servers.com", "dns2.registrar-servers.com"},
+			expectedUsingOurDNS: true,
+		},
+		"custom": {
+			response: getCustomNameserversResponse,
+			expected: []string{"ns1.example.net", "ns2.example.net"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ensureQueryParams(t, r, toURLValues(expectedValues))
+				w.Write([]byte(tc.response))
+			}))
+			t.Cleanup(ts.Close)
+
+			c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"))
+			if err != nil {
+				t.Fatalf("Error creating NewClient. Err: %s", err)
+			}
+
+			nameservers, usingOurDNS, err := c.GetNameservers(context.TODO(), namecheap.Domain{SLD: "domain", TLD: "com"})
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			if diff := cmp.Diff(tc.expected, nameservers); diff != "" {
+				t.Fatalf("Nameservers not equal to expected. Diff: %s", diff)
+			}
+			if usingOurDNS != tc.expectedUsingOurDNS {
+				t.Fatalf("Expected IsUsingOurDNS: %t. Got: %t", tc.expectedUsingOurDNS, usingOurDNS)
+			}
+		})
+	}
+}
+
+func TestGetTLDs(t *testing.T) {
+	expectedValues := map[string]string{
+		"ApiUser":  "testUser",
+		"ApiKey":   "testAPIKey",
+		"UserName": "testUser",
+		"ClientIp": "localhost",
+		"Command":  "namecheap.domains.getTldList",
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ensureQueryParams(t, r, toURLValues(expectedValues))
+		w.Write([]byte(getTLDListResponse))
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	tlds, err := c.GetTLDs(context.TODO())
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expectedTLDs := []namecheap.TLD{
+		{
+			Name:              "com",
+			Type:              "GTLD",
+			Category:          "P",
+			MinRegisterYears:  1,
+			MaxRegisterYears:  10,
+			IsAPIRegisterable: true,
+			IsAPIRenewable:    true,
+			IsAPITransferable: true,
+			IsSupportsIDN:     true,
+		},
+		{
+			Name:             "co.uk",
+			Type:             "CCTLD",
+			Category:         "A",
+			MinRegisterYears: 1,
+			MaxRegisterYears: 10,
+			IsAPIRenewable:   true,
+		},
+	}
+	if diff := cmp.Diff(expectedTLDs, tlds); diff != "" {
+		t.Fatalf("TLDs not equal to expected. Diff: %s", diff)
+	}
+}
+
+func TestSplitName(t *testing.T) {
+	tlds := map[string]bool{"com": true, "uk": true, "co.uk": true, "org.uk": true, "au": true, "com.au": true}
+	isTLD := func(tld string) bool { return tlds[tld] }
+
+	cases := map[string]struct {
+		name      string
+		domain    namecheap.Domain
+		subdomain string
+		err       string
+	}{
+		"com":                {name: "example.com.", domain: namecheap.Domain{SLD: "example", TLD: "com"}},
+		"co.uk":              {name: "foo.co.uk", domain: namecheap.Domain{SLD: "foo", TLD: "co.uk"}},
+		"org.uk":             {name: "foo.org.uk", domain: namecheap.Domain{SLD: "foo", TLD: "org.uk"}},
+		"com.au":             {name: "foo.com.au.", domain: namecheap.Domain{SLD: "foo", TLD: "com.au"}},
+		"single-label ccTLD": {name: "foo.uk", domain: namecheap.Domain{SLD: "foo", TLD: "uk"}},
+		// uk and co.uk are both TLDs so the longest suffix wins.
+		"ambiguous uk suffix":   {name: "example.co.uk", domain: namecheap.Domain{SLD: "example", TLD: "co.uk"}},
+		"ambiguous uk host":     {name: "shop.example.co.uk", domain: namecheap.Domain{SLD: "example", TLD: "co.uk"}, subdomain: "shop"},
+		"subdomain of co.uk":    {name: "api.foo.co.uk.", domain: namecheap.Domain{SLD: "foo", TLD: "co.uk"}, subdomain: "api"},
+		"nested subdomain":      {name: "a.b.example.com", domain: namecheap.Domain{SLD: "example", TLD: "com"}, subdomain: "a.b"},
+		"case insensitive":      {name: "API.Foo.CO.UK", domain: namecheap.Domain{SLD: "foo", TLD: "co.uk"}, subdomain: "api"},
+		"multi-label TLD alone": {name: "co.uk", err: "is a TLD"},
+		"unsupported TLD":       {name: "example.xyz", err: "has a TLD namecheap doesn't support"},
+		"invalid":               {name: "com", err: "not a valid domain"},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			domain, subdomain, err := namecheap.SplitName(tc.name, isTLD)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("Expected error containing %q. Got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			if domain != tc.domain || subdomain != tc.subdomain {
+				t.Fatalf("Expected: %+v %q. Got: %+v %q", tc.domain, tc.subdomain, domain, subdomain)
+			}
+		})
+	}
+}
+
+func TestTLDLookup(t *testing.T) {
+	var getTLDListRequests int
+	var splits []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch q.Get("Command") {
+		case "namecheap.domains.getTldList":
+			getTLDListRequests++
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<ApiResponse xmlns="http://api.namecheap.com/xml.response" Status="OK">
+  <Errors />
+  <CommandResponse Type="namecheap.domains.getTldList">
+    <Tlds>
+      <Tld Name="com" Type="GTLD" />
+      <Tld Name="au" Type="CCTLD" />
+      <Tld Name="com.au" Type="CCTLD" />
+    </Tlds>
+  </CommandResponse>
+</ApiResponse>`))
+		case "namecheap.domains.dns.getHosts":
+			splits = append(splits, q.Get("SLD")+" "+q.Get("TLD"))
+			w.Write([]byte(getHostsResponse))
+		default:
+			t.Fatalf("Unexpected command: %s", q.Get("Command"))
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"), namecheap.WithTLDLookup())
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	// Domains with two labels don't need the TLDs.
+	if _, err := c.GetHosts(context.TODO(), "foo.com"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if getTLDListRequests != 0 {
+		t.Fatalf("Expected no getTldList requests. Got: %d", getTLDListRequests)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetHosts(context.TODO(), "foo.com.au."); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+
+	_, err = c.GetHosts(context.TODO(), "api.foo.com.au")
+	if err == nil || !strings.Contains(err.Error(), "is a subdomain of foo.com.au") {
+		t.Fatalf("Expected subdomain error. Got: %v", err)
+	}
+
+	if diff := cmp.Diff([]string{"foo com", "foo com.au", "foo com.au"}, splits); diff != "" {
+		t.Fatalf("Unexpected SLD and TLD. Diff: %s", diff)
+	}
+
+	// The TLDs are fetched once.
+	if getTLDListRequests != 1 {
+		t.Fatalf("Expected 1 getTldList request. Got: %d", getTLDListRequests)
+	}
+}
+
+func TestSetDefaultNS(t *testing.T) {
+	expectedValues := map[string]string{
+		"ApiUser":  "testUser",
+		"ApiKey":   "testAPIKey",
+		"UserName": "testUser",
+		"ClientIp": "localhost",
+		"Command":  "namecheap.domains.dns.setDefault",
+		"SLD":      "domain",
+		"TLD":      "co.uk",
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ensureQueryParams(t, r, toURLValues(expectedValues))
+		w.Write([]byte(setDefaultResponse))
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	domain, err := namecheap.ParseDomain("domain.co.uk.")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if err := c.SetDefaultNS(context.TODO(), domain); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if err := c.SetDefaultNS(context.TODO(), namecheap.Domain{TLD: "com"}); err == nil {
+		t.Fatal("Expected error but got nil")
+	}
+}
+
+func TestSetCustomNS(t *testing.T) {
+	expectedValues := map[string]string{
+		"ApiUser":     "testUser",
+		"ApiKey":      "testAPIKey",
+		"UserName":    "testUser",
+		"ClientIp":    "localhost",
+		"Command":     "namecheap.domains.dns.setCustom",
+		"SLD":         "domain",
+		"TLD":         "com",
+		"Nameservers": "ns1.example.net,ns2.example.net",
+	}
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		ensureQueryParams(t, r, toURLValues(expectedValues))
+		w.Write([]byte(setCustomResponse))
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	domain := namecheap.Domain{SLD: "domain", TLD: "com"}
+	if err := c.SetCustomNS(context.TODO(), domain, []string{"ns1.example.net", "ns2.example.net"}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	err = c.SetCustomNS(context.TODO(), domain, []string{"ns1.example.net"})
+	if err == nil || !strings.Contains(err.Error(), "at least 2 custom nameservers") {
+		t.Fatalf("Expected error about too few nameservers. Got: %v", err)
+	}
+
+	if requests != 1 {
+		t.Fatalf("Expected 1 request. Got: %d", requests)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+
+	t.Run("request", func(t *testing.T) {
+		c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"), namecheap.WithRequestTimeout(50*time.Millisecond))
+		if err != nil {
+			t.Fatalf("Error creating NewClient. Err: %s", err)
+		}
+
+		_, err = c.GetHosts(context.TODO(), "domain.com")
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected deadline exceeded error. Got: %v", err)
+		}
+	})
+
+	t.Run("discovery", func(t *testing.T) {
+		_, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.AutoDiscoverPublicIP(), namecheap.WithDiscoveryAddress(ts.URL), namecheap.WithRequestTimeout(50*time.Millisecond))
+		if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+			t.Fatalf("Expected deadline exceeded error. Got: %v", err)
+		}
+	})
+
+	t.Run("discovery timeout", func(t *testing.T) {
+		_, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.AutoDiscoverPublicIP(), namecheap.WithDiscoveryAddress(ts.URL), namecheap.WithDiscoveryTimeout(50*time.Millisecond))
+		if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+			t.Fatalf("Expected deadline exceeded error. Got: %v", err)
+		}
+	})
+
+	// Reads are answered immediately while writes hang.
+	slowWrites := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		w.Write([]byte(getHostsResponse))
+	}))
+
+	t.Run("read timeout", func(t *testing.T) {
+		c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"), namecheap.WithReadTimeout(50*time.Millisecond), namecheap.WithWriteTimeout(time.Minute))
+		if err != nil {
+			t.Fatalf("Error creating NewClient. Err: %s", err)
+		}
+
+		_, err = c.GetHosts(context.TODO(), "domain.com")
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected deadline exceeded error. Got: %v", err)
+		}
+	})
+
+	t.Run("write timeout", func(t *testing.T) {
+		c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(slowWrites.URL), namecheap.WithClientIP("localhost"), namecheap.WithReadTimeout(0), namecheap.WithWriteTimeout(50*time.Millisecond))
+		if err != nil {
+			t.Fatalf("Error creating NewClient. Err: %s", err)
+		}
+
+		// The read of the existing hosts succeeds and only the write times out.
+		_, err = c.AddHosts(context.TODO(), "domain.com", []namecheap.HostRecord{{Name: "new", RecordType: namecheap.A, Address: "1.2.3.4"}})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected deadline exceeded error. Got: %v", err)
+		}
+	})
+
+	t.Run("read timeout doesn't apply to writes", func(t *testing.T) {
+		c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(slowWrites.URL), namecheap.WithClientIP("localhost"), namecheap.WithReadTimeout(50*time.Millisecond), namecheap.WithWriteTimeout(0))
+		if err != nil {
+			t.Fatalf("Error creating NewClient. Err: %s", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		// Only the caller's context ends the write.
+		_, err = c.AddHosts(ctx, "domain.com", []namecheap.HostRecord{{Name: "new", RecordType: namecheap.A, Address: "1.2.3.4"}})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected deadline exceeded error. Got: %v", err)
+		}
+		if ctx.Err() == nil {
+			t.Fatal("Expected the write to run until the caller's deadline")
+		}
+	})
+
+	ts.Close()
+	slowWrites.Close()
+	http.DefaultClient.CloseIdleConnections()
+
+	// The goroutines of the timed out requests should all exit.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected at most %d goroutines. Got: %d", before, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestIPNotWhitelisted(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(ipNotWhitelistedResponse))
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("203.0.113.7"))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	_, err = c.GetHosts(context.TODO(), "domain.com")
+	if !errors.Is(err, namecheap.ErrIPNotWhitelisted) {
+		t.Fatalf("Expected ErrIPNotWhitelisted. Got: %v", err)
+	}
+
+	if !strings.Contains(err.Error(), "203.0.113.7") {
+		t.Fatalf("Expected error to contain the client IP. Got: %s", err)
+	}
+
+	var apiErr *namecheap.APIError
+	if !errors.As(err, &apiErr) || apiErr.Number != 1011150 {
+		t.Fatalf("Expected APIError with number 1011150. Got: %v", err)
+	}
+}
+
+func TestPremiumDNSRequired(t *testing.T) {
+	respond := func(number, message string) *httptest.Server {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>
+<ApiResponse Status="ERROR" xmlns="http://api.namecheap.com/xml.response">
+  <Errors>
+    <Error Number="%s">%s</Error>
+  </Errors>
+  <Warnings />
+  <RequestedCommand />
+</ApiResponse>`, number, message)
+		}))
+		t.Cleanup(ts.Close)
+		return ts
+	}
+
+	cases := map[string]struct {
+		server   *httptest.Server
+		options  []namecheap.ClientOption
+		expected bool
+	}{
+		"message": {
+			server:   respond("2011170", "TTL below 300 is only available with Premium DNS"),
+			expected: true,
+		},
+		"configured number": {
+			server:   respond("2011170", "Invalid TTL"),
+			options:  []namecheap.ClientOption{namecheap.WithPremiumDNSErrors(2011170)},
+			expected: true,
+		},
+		"other error": {
+			server:   respond("2011170", "Invalid TTL"),
+			expected: false,
+		},
+		"full response": {
+			server: func() *httptest.Server {
+				ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					fmt.Fprint(w, `<?xml version="1.0" encoding="utf-8"?>
+<ApiResponse Status="ERROR" xmlns="http://api.namecheap.com/xml.response">
+  <Errors>
+    <Error Number="2011170">Validation error: invalid TTL for host @</Error>
+    <Error Number="2011170">TTL lower than 1799 requires a PremiumDNS subscription</Error>
+  </Errors>
+  <Warnings />
+  <RequestedCommand>namecheap.domains.dns.gethosts</RequestedCommand>
+  <Server>PHX01SBAPIEXT01</Server>
+  <GMTTimeDifference>--5:00</GMTTimeDifference>
+  <ExecutionTime>0.016</ExecutionTime>
+</ApiResponse>`)
+				}))
+				t.Cleanup(ts.Close)
+				return ts
+			}(),
+			expected: true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			options := append([]namecheap.ClientOption{namecheap.WithEndpoint(tc.server.URL), namecheap.WithClientIP("localhost")}, tc.options...)
+			c, err := namecheap.NewClient("testAPIKey", "testUser", options...)
+			if err != nil {
+				t.Fatalf("Error creating NewClient. Err: %s", err)
+			}
+
+			_, err = c.GetHosts(context.TODO(), "domain.com")
+			if got := errors.Is(err, namecheap.ErrPremiumDNSRequired); got != tc.expected {
+				t.Fatalf("Expected ErrPremiumDNSRequired match: %t. Got: %v", tc.expected, err)
+			}
+
+			var apiErr *namecheap.APIError
+			if !errors.As(err, &apiErr) || apiErr.Number != 2011170 {
+				t.Fatalf("Expected APIError with number 2011170. Got: %v", err)
+			}
+		})
+	}
+}
+
+func TestRateLimitErrors(t *testing.T) {
+	cases := map[string]struct {
+		number         string
+		message        string
+		options        []namecheap.ClientOption
+		expectedWindow time.Duration
+	}{
+		"per-minute number": {
+			number:         "500000",
+			message:        "Too many requests",
+			options:        []namecheap.ClientOption{namecheap.WithRateLimitErrors(time.Minute, 500000)},
+			expectedWindow: time.Minute,
+		},
+		"per-hour number": {
+			number:         "500001",
+			message:        "Too many requests",
+			options:        []namecheap.ClientOption{namecheap.WithRateLimitErrors(time.Hour, 500001)},
+			expectedWindow: time.Hour,
+		},
+		"per-hour message": {
+			number:         "500001",
+			message:        "Too many requests per hour",
+			expectedWindow: time.Hour,
+		},
+		"per-day message": {
+			number:         "500002",
+			message:        "Too many requests per day",
+			expectedWindow: 24 * time.Hour,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var requests int
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>
+<ApiResponse Status="ERROR" xmlns="http://api.namecheap.com/xml.response">
+  <Errors>
+    <Error Number="%s">%s</Error>
+  </Errors>
+  <Warnings />
+  <RequestedCommand />
+</ApiResponse>`, tc.number, tc.message)
+			}))
+			t.Cleanup(ts.Close)
+
+			options := append([]namecheap.ClientOption{namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"), namecheap.WithRetry(3, time.Millisecond)}, tc.options...)
+			c, err := namecheap.NewClient("testAPIKey", "testUser", options...)
+			if err != nil {
+				t.Fatalf("Error creating NewClient. Err: %s", err)
+			}
+
+			// The retry has to wait for the window which can't finish
+			// before the deadline, so the error is returned right away.
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			_, err = c.GetHosts(ctx, "domain.com")
+			if !errors.Is(err, namecheap.ErrRateLimited) {
+				t.Fatalf("Expected ErrRateLimited. Got: %v", err)
+			}
+
+			var rateLimitErr *namecheap.RateLimitError
+			if !errors.As(err, &rateLimitErr) {
+				t.Fatalf("Expected RateLimitError. Got: %v", err)
+			}
+			if rateLimitErr.Window != tc.expectedWindow {
+				t.Fatalf("Expected window: %s. Got: %s", tc.expectedWindow, rateLimitErr.Window)
+			}
+			if wait := rateLimitErr.RetryAfter(); wait != tc.expectedWindow {
+				t.Fatalf("Expected wait: %s. Got: %s", tc.expectedWindow, wait)
+			}
+			if requests != 1 {
+				t.Fatalf("Expected 1 request. Got: %d", requests)
+			}
+		})
+	}
+
+	if _, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithClientIP("localhost"), namecheap.WithRateLimitErrors(time.Second, 500000)); err == nil {
+		t.Fatal("Expected error for an unknown window but got nil")
+	}
+}
+
+func TestRateLimitWaitIsCapped(t *testing.T) {
+	cases := map[string]struct {
+		message string
+		options []namecheap.ClientOption
+	}{
+		"per-hour quota over the default": {
+			message: "Too many requests per hour",
+		},
+		"per-minute quota over a lower limit": {
+			message: "Too many requests",
+			options: []namecheap.ClientOption{namecheap.WithMaxRateLimitWait(time.Second)},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var requests atomic.Int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>
+<ApiResponse Status="ERROR" xmlns="http://api.namecheap.com/xml.response">
+  <Errors>
+    <Error Number="500000">%s</Error>
+  </Errors>
+  <Warnings />
+  <RequestedCommand />
+</ApiResponse>`, tc.message)
+			}))
+			t.Cleanup(ts.Close)
+
+			options := append([]namecheap.ClientOption{namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"), namecheap.WithRetry(3, time.Millisecond)}, tc.options...)
+			c, err := namecheap.NewClient("testAPIKey", "testUser", options...)
+			if err != nil {
+				t.Fatalf("Error creating NewClient. Err: %s", err)
+			}
+
+			// Without a deadline the request would otherwise wait out the
+			// whole window.
+			_, err = c.GetHosts(context.Background(), "domain.com")
+			if !errors.Is(err, namecheap.ErrRateLimited) {
+				t.Fatalf("Expected ErrRateLimited. Got: %v", err)
+			}
+			if got := requests.Load(); got != 1 {
+				t.Fatalf("Expected 1 request. Got: %d", got)
+			}
+		})
+	}
+
+	if _, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithClientIP("localhost"), namecheap.WithMaxRateLimitWait(-time.Second)); err == nil {
+		t.Fatal("Expected error for a negative wait but got nil")
+	}
+}
+
+func TestGetHostsTTLBoundaries(t *testing.T) {
+	response := strings.Replace(getHostsResponse, `Address="1.2.3.4" MXPref="10" TTL="1800"`, `Address="1.2.3.4" MXPref="10" TTL="60"`, 1)
+	response = strings.Replace(response, `Address="122.23.3.7" MXPref="10" TTL="1800"`, `Address="122.23.3.7" MXPref="10" TTL="60000"`, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	hosts, err := c.GetHosts(context.TODO(), "domain.com")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if hosts[0].TTL != 60 || hosts[1].TTL != 60000 {
+		t.Fatalf("Expected TTLs 60 and 60000. Got: %d and %d", hosts[0].TTL, hosts[1].TTL)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			w.Write([]byte(setHostsResponse))
+		case http.MethodGet:
+			w.Write([]byte(emptyHostsResponse))
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	c, err := namecheap.NewClient("test/API+Key", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"), namecheap.WithLogger(logger))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	hosts := []namecheap.HostRecord{
+		{Name: "www", RecordType: namecheap.A, Address: "1.2.3.4"},
+		{Name: "mail", RecordType: namecheap.A, Address: "1.2.3.4"},
+	}
+	if _, err := c.SetHosts(context.TODO(), "domain.com", hosts); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// A failed request's error includes the request URL.
+	ts.Close()
+	if _, err := c.GetHosts(context.TODO(), "domain.com"); err == nil {
+		t.Fatal("Expected error but got nil")
+	}
+
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		if strings.Contains(line, "test/API+Key") || strings.Contains(line, url.QueryEscape("test/API+Key")) {
+			t.Fatalf("Log contains the API key: %s", line)
+		}
+
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Unable to parse log. Err: %s", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 log entries. Got: %d", len(entries))
+	}
+
+	setHosts := entries[1]
+	if setHosts["command"] != "namecheap.domains.dns.setHosts" || setHosts["domain"] != "domain.com" || setHosts["hosts"] != float64(2) || setHosts["status"] != "OK" {
+		t.Fatalf("Unexpected setHosts log entry: %v", setHosts)
+	}
+
+	if _, ok := entries[2]["error"]; !ok {
+		t.Fatalf("Expected error in log entry: %v", entries[2])
+	}
+}
+
+func TestRequestHooks(t *testing.T) {
+	var fail atomic.Bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Requests take a moment so the duration is never zero.
+		time.Sleep(time.Millisecond)
+		if fail.Load() {
+			w.Write([]byte(errorResponse))
+			return
+		}
+		w.Write([]byte(getHostsResponse))
+	}))
+	t.Cleanup(ts.Close)
+
+	type response struct {
+		command string
+		status  string
+		d       time.Duration
+		err     error
+	}
+	var requested []string
+	var responses []response
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"),
+		namecheap.WithRequestHook(func(ctx context.Context, command string) {
+			requested = append(requested, command)
+		}),
+		namecheap.WithResponseHook(func(ctx context.Context, command, status string, d time.Duration, err error) {
+			responses = append(responses, response{command: command, status: status, d: d, err: err})
+		}),
+	)
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	if _, err := c.GetHosts(context.TODO(), "domain.com"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	fail.Store(true)
+	_, err = c.GetHosts(context.TODO(), "domain.com")
+	if err == nil {
+		t.Fatal("Expected error but got nil")
+	}
+
+	if diff := cmp.Diff([]string{"namecheap.domains.dns.getHosts", "namecheap.domains.dns.getHosts"}, requested); diff != "" {
+		t.Fatalf("Requested commands not equal to expected. Diff: %s", diff)
+	}
+	if len(responses) != 2 {
+		t.Fatalf("Expected 2 responses. Got: %d", len(responses))
+	}
+	for i, resp := range responses {
+		if resp.command != "namecheap.domains.dns.getHosts" {
+			t.Fatalf("Expected command namecheap.domains.dns.getHosts. Got: %s", resp.command)
+		}
+		if resp.d <= 0 {
+			t.Fatalf("Expected a non-zero duration for response %d", i)
+		}
+	}
+	if responses[0].status != "OK" || responses[0].err != nil {
+		t.Fatalf("Expected a successful response. Got: %+v", responses[0])
+	}
+	if responses[1].status != "ERROR" || responses[1].err == nil {
+		t.Fatalf("Expected a failed response. Got: %+v", responses[1])
+	}
+
+	// The hook sees the error returned to the caller.
+	var apiErr *namecheap.APIError
+	if !errors.As(responses[1].err, &apiErr) || !errors.As(err, &apiErr) {
+		t.Fatalf("Expected APIError. Got: %v and %v", responses[1].err, err)
+	}
+}
+
+// fakeMetrics counts what it receives.
+type fakeMetrics struct {
+	mu        sync.Mutex
+	calls     map[string]int
+	errors    map[string]int
+	latencies map[string][]time.Duration
+}
+
+func (m *fakeMetrics) IncCall(command string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls[command]++
+}
+
+func (m *fakeMetrics) IncError(command, number string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors[command+"/"+number]++
+}
+
+func (m *fakeMetrics) ObserveLatency(command string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latencies[command] = append(m.latencies[command], d)
+}
+
+func TestMetrics(t *testing.T) {
+	var fail atomic.Bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.Write([]byte(errorResponse))
+			return
+		}
+		w.Write([]byte(getHostsResponse))
+	}))
+	t.Cleanup(ts.Close)
+
+	metrics := &fakeMetrics{calls: map[string]int{}, errors: map[string]int{}, latencies: map[string][]time.Duration{}}
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"), namecheap.WithMetrics(metrics))
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	if _, err := c.GetHosts(context.TODO(), "domain.com"); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	fail.Store(true)
+	if _, err := c.GetHosts(context.TODO(), "domain.com"); err == nil {
+		t.Fatal("Expected error but got nil")
+	}
+
+	if diff := cmp.Diff(map[string]int{"namecheap.domains.dns.getHosts": 2}, metrics.calls); diff != "" {
+		t.Fatalf("Calls not equal to expected. Diff: %s", diff)
+	}
+	if diff := cmp.Diff(map[string]int{"namecheap.domains.dns.getHosts/1010102": 1}, metrics.errors); diff != "" {
+		t.Fatalf("Errors not equal to expected. Diff: %s", diff)
+	}
+	if got := len(metrics.latencies["namecheap.domains.dns.getHosts"]); got != 2 {
+		t.Fatalf("Expected 2 latencies. Got: %d", got)
+	}
+}
+
+func TestOptimisticLocking(t *testing.T) {
+	var getHostsRequests int
+	var postedNames []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			query := r.URL.Query()
+			for i := 1; query.Has(fmt.Sprintf("HostName%d", i)); i++ {
+				postedNames = append(postedNames, query.Get(fmt.Sprintf("HostName%d", i)))
+			}
+			w.Write([]byte(setHostsResponse))
+			return
+		}
+
+		// Another writer adds a host between the first read and the write.
+		getHostsRequests++
+		if getHostsRequests == 1 {
+			w.Write([]byte(getHostsResponse))
+			return
+		}
+		w.Write([]byte(modifiedHostsResponse))
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"), namecheap.WithOptimisticLocking())
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	_, err = c.AddHosts(context.TODO(), "domain.com", []namecheap.HostRecord{
+		{Name: "new", RecordType: namecheap.A, Address: "122.23.3.9", TTL: 1800},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// One read to modify, one to detect the change and one to verify the retry.
+	if getHostsRequests != 3 {
+		t.Fatalf("Expected 3 getHosts requests. Got: %d", getHostsRequests)
+	}
+
+	expectedNames := []string{"@", "www", "mail", "new"}
+	if diff := cmp.Diff(expectedNames, postedNames); diff != "" {
+		t.Fatalf("Unexpected hosts written. Diff: %s", diff)
+	}
+}
+
+func TestOptimisticLockingGivesUp(t *testing.T) {
+	var getHostsRequests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			t.Fatal("Unexpected setHosts request")
+		}
+
+		// The hosts change on every read.
+		getHostsRequests++
+		if getHostsRequests%2 == 1 {
+			w.Write([]byte(getHostsResponse))
+			return
+		}
+		w.Write([]byte(modifiedHostsResponse))
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := namecheap.NewClient("testAPIKey", "testUser", namecheap.WithEndpoint(ts.URL), namecheap.WithClientIP("localhost"), namecheap.WithOptimisticLocking())
+	if err != nil {
+		t.Fatalf("Error creating NewClient. Err: %s", err)
+	}
+
+	_, err = c.AddHosts(context.TODO(), "domain.com", []namecheap.HostRecord{
+		{Name: "new", RecordType: namecheap.A, Address: "122.23.3.9", TTL: 1800},
+	})
+	if !errors.Is(err, namecheap.ErrConcurrentModification) {
+		t.Fatalf("Expected ErrConcurrentModification. Got: %v", err)
+	}
+}
+
+func TestHostKey(t *testing.T) {
+	host := namecheap.HostRecord{Name: "WWW", RecordType: namecheap.A, Address: "1.2.3.4", HostID: "1", TTL: 300}
+
+	// Names differ only in case and IDs and TTLs aren't part of the key.
+	same := namecheap.HostRecord{Name: "www", RecordType: namecheap.A, Address: "1.2.3.4", HostID: "2", TTL: 1800}
+	if namecheap.HostKey(host) != namecheap.HostKey(same) {
+		t.Fatalf("Expected hosts to have the same key. Got: %s and %s", namecheap.HostKey(host), namecheap.HostKey(same))
+	}
+
+	other := namecheap.HostRecord{Name: "www", RecordType: namecheap.A, Address: "5.6.7.8"}
+	if namecheap.HostKey(host) == namecheap.HostKey(other) {
+		t.Fatalf("Expected hosts with different addresses to have different keys. Got: %s", namecheap.HostKey(host))
+	}
+}
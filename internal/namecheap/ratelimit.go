@@ -0,0 +1,196 @@
+package namecheap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter spaces out requests so they stay under namecheap's API quotas.
+// It doesn't allow bursts: every request is sent at least one interval after
+// the previous one, so requests go out evenly at the configured rate. It is
+// safe for concurrent use and can be shared between clients.
+type RateLimiter struct {
+	interval time.Duration
+
+	mu sync.Mutex
+	// The earliest time the next request may be sent.
+	next time.Time
+}
+
+// NewRateLimiter creates a limiter that allows perMinute requests per minute.
+func NewRateLimiter(perMinute int) *RateLimiter {
+	return &RateLimiter{
+		interval: time.Minute / time.Duration(perMinute),
+	}
+}
+
+// Wait blocks until a request may be sent or ctx is done. A Wait that ends
+// because ctx is done gives its slot back, unless a later Wait has already
+// been scheduled after it.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	reserved := at.Add(l.interval)
+	l.next = reserved
+	l.mu.Unlock()
+
+	delay := time.Until(at)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		l.mu.Lock()
+		if l.next.Equal(reserved) {
+			l.next = at
+		}
+		l.mu.Unlock()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// WithRateLimit limits the client to perMinute requests per minute.
+// Requests block until they can be sent rather than failing.
+func WithRateLimit(perMinute int) ClientOption {
+	return func(c *Client) error {
+		if perMinute <= 0 {
+			return fmt.Errorf("rate limit must be positive. Got: %d", perMinute)
+		}
+		c.rateLimiter = NewRateLimiter(perMinute)
+		return nil
+	}
+}
+
+// WithRateLimiter limits the client's requests with limiter.
+// Sharing a limiter between clients limits their combined requests.
+func WithRateLimiter(limiter *RateLimiter) ClientOption {
+	return func(c *Client) error {
+		c.rateLimiter = limiter
+		return nil
+	}
+}
+
+// ErrRateLimited is matched by errors.Is when namecheap rejects a request
+// because the account exceeded one of its API quotas.
+var ErrRateLimited = errors.New("namecheap API rate limit exceeded")
+
+// RateLimitError is returned when namecheap rejects a request because the
+// account sent too many requests within Window: a minute, an hour or a day.
+type RateLimitError struct {
+	Window time.Duration
+	Err    *APIError
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("namecheap API rate limit exceeded for the %s window. Err: %s", windowName(e.Window), e.Err)
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return e.Err
+}
+
+// RetryAfter is how long to wait before the request is likely to succeed.
+// The quota is counted over the whole window so waiting out a full window is
+// the only wait guaranteed to free up requests.
+func (e *RateLimitError) RetryAfter() time.Duration {
+	return e.Window
+}
+
+// The windows namecheap counts requests over.
+const (
+	rateLimitWindowMinute = time.Minute
+	rateLimitWindowHour   = time.Hour
+	rateLimitWindowDay    = 24 * time.Hour
+)
+
+// defaultMaxRateLimitWait lets requests wait out the per-minute quota but not
+// the per-hour or per-day ones.
+const defaultMaxRateLimitWait = time.Minute
+
+// WithMaxRateLimitWait sets the longest a rate limited request waits for its
+// quota's window before it is retried. Requests whose quota's window is longer
+// fail with the RateLimitError right away instead of blocking e.g. for a day.
+// Defaults to a minute.
+func WithMaxRateLimitWait(d time.Duration) ClientOption {
+	return func(c *Client) error {
+		if d < 0 {
+			return fmt.Errorf("rate limit wait must not be negative. Got: %s", d)
+		}
+		c.maxRateLimitWait = d
+		return nil
+	}
+}
+
+func windowName(window time.Duration) string {
+	switch window {
+	case rateLimitWindowMinute:
+		return "per-minute"
+	case rateLimitWindowHour:
+		return "per-hour"
+	case rateLimitWindowDay:
+		return "per-day"
+	}
+	return window.String()
+}
+
+// WithRateLimitErrors maps namecheap error numbers to the quota window they
+// report being exceeded. window must be a minute, an hour or 24 hours.
+// namecheap doesn't document the numbers of its rate limit errors so errors
+// whose message mentions too many requests per minute, hour or day are
+// recognized without being added.
+func WithRateLimitErrors(window time.Duration, numbers ...int) ClientOption {
+	return func(c *Client) error {
+		switch window {
+		case rateLimitWindowMinute, rateLimitWindowHour, rateLimitWindowDay:
+		default:
+			return fmt.Errorf("rate limit window must be a minute, an hour or a day. Got: %s", window)
+		}
+		if c.rateLimitErrors == nil {
+			c.rateLimitErrors = make(map[int]time.Duration)
+		}
+		for _, number := range numbers {
+			c.rateLimitErrors[number] = window
+		}
+		return nil
+	}
+}
+
+// rateLimitWindow returns the quota window apiErr reports being exceeded or
+// zero if it isn't a rate limit error.
+func (c *Client) rateLimitWindow(apiErr *APIError) time.Duration {
+	for _, number := range apiErr.Numbers {
+		if window, ok := c.rateLimitErrors[number]; ok {
+			return window
+		}
+	}
+
+	message := strings.ToLower(apiErr.Message)
+	if !strings.Contains(message, "too many requests") && !strings.Contains(message, "rate limit") {
+		return 0
+	}
+	switch {
+	case strings.Contains(message, "day"):
+		return rateLimitWindowDay
+	case strings.Contains(message, "hour"):
+		return rateLimitWindowHour
+	}
+	return rateLimitWindowMinute
+}
@@ -0,0 +1,128 @@
+package namecheap
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// defaultRetryableErrors are the namecheap error numbers known to be transient.
+func defaultRetryableErrors() map[int]bool {
+	return map[int]bool{
+		// DNS setup failed. Returned by setHosts when namecheap is under load.
+		2030166: true,
+	}
+}
+
+// WithRetry retries requests that fail with an HTTP 5xx status, a retryable
+// namecheap error or a RateLimitError. Rate limited requests wait for the
+// exceeded quota's window instead of the usual delay, unless the window is
+// longer than the limit set with WithMaxRateLimitWait. Each request is
+// attempted at most maxAttempts times. The delay before the first retry is
+// around base and doubles with every retry.
+func WithRetry(maxAttempts int, base time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.maxAttempts = maxAttempts
+		c.retryBaseDelay = base
+		return nil
+	}
+}
+
+// WithRetryableErrors adds namecheap error numbers that should be retried
+// when retries are enabled with WithRetry.
+func WithRetryableErrors(numbers ...int) ClientOption {
+	return func(c *Client) error {
+		for _, number := range numbers {
+			c.retryableErrors[number] = true
+		}
+		return nil
+	}
+}
+
+// doRequest sends req, retrying transient failures if retries are enabled.
+func (c *Client) doRequest(req *http.Request) (*apiResponse, error) {
+	ctx := req.Context()
+
+	for attempt := 1; ; attempt++ {
+		// Retries count towards the quotas too.
+		if c.rateLimiter != nil {
+			if err := c.rateLimiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		c.hookRequest(req)
+		start := time.Now()
+		apiResp, err := c.sendRequest(req)
+		c.hookResponse(req, apiResp, time.Since(start), err)
+		c.logRequest(req, attempt, apiResp, err)
+		if err == nil {
+			return apiResp, nil
+		}
+
+		if attempt >= c.maxAttempts || !c.isRetryable(apiResp, err) {
+			return nil, err
+		}
+
+		delay := backoff(c.retryBaseDelay, attempt)
+
+		// Backing off doesn't help until the exceeded quota's window passes.
+		var rateLimitErr *RateLimitError
+		if errors.As(err, &rateLimitErr) {
+			delay = rateLimitErr.RetryAfter()
+			if delay > c.maxRateLimitWait {
+				return nil, err
+			}
+		}
+
+		// Don't wait for a retry that can't finish in time.
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// isRetryable reports whether the failed request is worth retrying.
+func (c *Client) isRetryable(apiResp *apiResponse, err error) bool {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return true
+	}
+
+	var rateLimitErr *RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		return true
+	}
+
+	if apiResp == nil {
+		return false
+	}
+
+	for _, number := range apiResp.Errors.toAPIError().Numbers {
+		if c.retryableErrors[number] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backoff returns the delay before the retry following attempt. The delay
+// doubles with each attempt and is randomized by up to half to avoid
+// clients retrying in lockstep.
+func backoff(base time.Duration, attempt int) time.Duration {
+	delay := base << (attempt - 1)
+	if delay <= 0 {
+		return 0
+	}
+
+	half := int64(delay / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
@@ -0,0 +1,117 @@
+package namecheap
+
+import "fmt"
+
+const (
+	// defaultMaxRequestSize is a conservative estimate of the largest setHosts
+	// request namecheap accepts. namecheap doesn't document a limit but the
+	// hosts are sent as URL parameters and servers limit the length of URLs.
+	defaultMaxRequestSize = 32 * 1024
+
+	// Requests at least this fraction of the limit are warned about.
+	requestSizeWarningRatio = 0.9
+)
+
+// WithRequestSizeLimit sets the largest setHosts request in bytes. Larger
+// requests fail with a RequestTooLargeError without being sent and requests
+// close to the limit are warned about.
+func WithRequestSizeLimit(maxBytes int) ClientOption {
+	return func(c *Client) error {
+		if maxBytes <= 0 {
+			return fmt.Errorf("request size limit must be positive. Got: %d", maxBytes)
+		}
+		c.maxRequestSize = maxBytes
+		return nil
+	}
+}
+
+// WithHostLimit sets the most hosts a setHosts request may carry. Requests
+// with more hosts fail with a TooManyHostsError without being sent.
+func WithHostLimit(maxHosts int) ClientOption {
+	return func(c *Client) error {
+		if maxHosts <= 0 {
+			return fmt.Errorf("host limit must be positive. Got: %d", maxHosts)
+		}
+		c.maxHosts = maxHosts
+		return nil
+	}
+}
+
+// WithWarnings calls warn with warnings about requests that are close to
+// namecheap's limits.
+func WithWarnings(warn func(warning string)) ClientOption {
+	return func(c *Client) error {
+		c.warn = warn
+		return nil
+	}
+}
+
+// RequestTooLargeError is returned when the hosts of a domain don't fit in a
+// single setHosts request. setHosts always replaces every host of a domain so
+// the request can't be split into smaller ones.
+type RequestTooLargeError struct {
+	Domain string
+
+	// Size and Limit of the request in bytes.
+	Size  int
+	Limit int
+
+	// Hosts is the number of hosts in the request and MaxHosts an estimate
+	// of how many hosts of the same average size would fit.
+	Hosts    int
+	MaxHosts int
+}
+
+func (e *RequestTooLargeError) Error() string {
+	return fmt.Sprintf("setHosts request for domain: %s is %d bytes which is over the limit of %d bytes. "+
+		"namecheap replaces all the hosts of a domain at once so the request can't be split. "+
+		"Reduce the domain from %d to at most about %d records, for example by removing unused records or moving some to a subdomain served elsewhere",
+		e.Domain, e.Size, e.Limit, e.Hosts, e.MaxHosts)
+}
+
+// TooManyHostsError is returned when a setHosts request would carry more
+// hosts than the limit set with WithHostLimit.
+type TooManyHostsError struct {
+	Domain string
+	Hosts  int
+	Limit  int
+}
+
+func (e *TooManyHostsError) Error() string {
+	return fmt.Sprintf("setHosts request for domain: %s has %d hosts which is over the limit of %d hosts. "+
+		"namecheap replaces all the hosts of a domain at once so the request can't be split. "+
+		"Reduce the domain to at most %d records, for example by removing unused records or moving some to a subdomain served elsewhere",
+		e.Domain, e.Hosts, e.Limit, e.Limit)
+}
+
+// checkHostCount fails requests with more hosts than the host limit.
+func (c *Client) checkHostCount(domain string, hosts int) error {
+	if c.maxHosts > 0 && hosts > c.maxHosts {
+		return &TooManyHostsError{Domain: domain, Hosts: hosts, Limit: c.maxHosts}
+	}
+	return nil
+}
+
+// checkRequestSize fails requests over the size limit and warns about
+// requests close to it.
+func (c *Client) checkRequestSize(domain string, size, hosts int) error {
+	if size > c.maxRequestSize {
+		maxHosts := 0
+		if hosts > 0 {
+			maxHosts = hosts * c.maxRequestSize / size
+		}
+		return &RequestTooLargeError{
+			Domain:   domain,
+			Size:     size,
+			Limit:    c.maxRequestSize,
+			Hosts:    hosts,
+			MaxHosts: maxHosts,
+		}
+	}
+
+	if c.warn != nil && float64(size) >= float64(c.maxRequestSize)*requestSizeWarningRatio {
+		c.warn(fmt.Sprintf("setHosts request for domain: %s with %d records is %d bytes which is close to the limit of %d bytes", domain, hosts, size, c.maxRequestSize))
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package namecheap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TLD describes a top-level domain namecheap supports.
+type TLD struct {
+	// Name is the TLD without a leading dot e.g. com or co.uk
+	Name string
+
+	// Type is the kind of TLD e.g. GTLD or CCTLD
+	Type string
+
+	// Category is namecheap's category code of the TLD e.g. P for popular.
+	Category string
+
+	// The range of years a domain under the TLD can be registered for.
+	MinRegisterYears int
+	MaxRegisterYears int
+
+	// Whether domains under the TLD can be registered, renewed and
+	// transferred through the API.
+	IsAPIRegisterable bool
+	IsAPIRenewable    bool
+	IsAPITransferable bool
+
+	// IsSupportsIDN is whether internationalized domain names can be
+	// registered under the TLD.
+	IsSupportsIDN bool
+}
+
+// This gets unmarshalled from the server's XML response.
+type getTLDListResponseTLD struct {
+	Name              string `xml:"Name,attr"`
+	Type              string `xml:"Type,attr"`
+	Category          string `xml:"Category,attr"`
+	MinRegisterYears  int    `xml:"MinRegisterYears,attr"`
+	MaxRegisterYears  int    `xml:"MaxRegisterYears,attr"`
+	IsAPIRegisterable bool   `xml:"IsApiRegisterable,attr"`
+	IsAPIRenewable    bool   `xml:"IsApiRenewable,attr"`
+	IsAPITransferable bool   `xml:"IsApiTransferable,attr"`
+	IsSupportsIDN     bool   `xml:"IsSupportsIDN,attr"`
+}
+
+// Converts the XML response into the public TLD struct.
+func (t getTLDListResponseTLD) ToTLD() TLD {
+	return TLD{
+		Name:              t.Name,
+		Type:              t.Type,
+		Category:          t.Category,
+		MinRegisterYears:  t.MinRegisterYears,
+		MaxRegisterYears:  t.MaxRegisterYears,
+		IsAPIRegisterable: t.IsAPIRegisterable,
+		IsAPIRenewable:    t.IsAPIRenewable,
+		IsAPITransferable: t.IsAPITransferable,
+		IsSupportsIDN:     t.IsSupportsIDN,
+	}
+}
+
+type tldListResult struct {
+	TLDs []getTLDListResponseTLD `xml:"Tld"`
+}
+
+// GetTLDs returns the TLDs namecheap supports along with their metadata.
+// namecheap returns all of them at once so there is no paging.
+func (c *Client) GetTLDs(ctx context.Context) ([]TLD, error) {
+	ctx, cancel := c.withBudget(ctx)
+	defer cancel()
+
+	u := c.buildCommandURL("namecheap.domains.getTldList", url.Values{})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	apiResp, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := apiResp.CommandResponse.TLDs
+	if result == nil {
+		return nil, errors.New("namecheap api returned no TLDs")
+	}
+
+	tlds := make([]TLD, 0, len(result.TLDs))
+	names := make(map[string]bool, len(result.TLDs))
+	for _, t := range result.TLDs {
+		tlds = append(tlds, t.ToTLD())
+		names[strings.ToLower(t.Name)] = true
+	}
+
+	c.tldMu.Lock()
+	c.tldNames = names
+	c.tldMu.Unlock()
+
+	return tlds, nil
+}
+
+// WithTLDLookup makes the client check domains with more than two labels,
+// such as example.com.au or www.example.com, against the TLDs namecheap
+// supports. The longest supported TLD the domain ends with is taken as its
+// TLD so that subdomains of registered domains are rejected with a clear
+// error instead of being sent to namecheap. The TLDs are fetched once, the
+// first time such a domain is used. Domains with two labels are never
+// looked up.
+func WithTLDLookup() ClientOption {
+	return func(c *Client) error {
+		c.tldLookup = true
+		return nil
+	}
+}
+
+// SplitName splits name into the registered domain it belongs to and the
+// labels in front of it, using the longest TLD the name ends with for which
+// isTLD reports true. For example www.example.co.uk is split into
+// example.co.uk and www when co.uk is a TLD. subdomain is empty when name is
+// the registered domain itself.
+func SplitName(name string, isTLD func(tld string) bool) (domain Domain, subdomain string, err error) {
+	// Validates the name the same way for every caller.
+	if _, err := ParseDomain(name); err != nil {
+		return Domain{}, "", err
+	}
+
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(name, ".")), ".")
+	if isTLD(strings.Join(labels, ".")) {
+		return Domain{}, "", fmt.Errorf("domain: %s is a TLD. Expected a registrable domain under it", name)
+	}
+
+	for i := 1; i < len(labels); i++ {
+		tld := strings.Join(labels[i:], ".")
+		if isTLD(tld) {
+			return Domain{SLD: labels[i-1], TLD: tld}, strings.Join(labels[:i-1], "."), nil
+		}
+	}
+
+	return Domain{}, "", fmt.Errorf("domain: %s has a TLD namecheap doesn't support. The records of domains under it can't be managed through the namecheap API", name)
+}
+
+// ResolveDomain splits domain into its SLD and TLD. With WithTLDLookup the
+// TLDs namecheap supports are used to reject subdomains of registered
+// domains. Every command that takes a domain name resolves it this way.
+func (c *Client) ResolveDomain(ctx context.Context, domain string) (Domain, error) {
+	d, err := ParseDomain(domain)
+	if err != nil || !c.tldLookup || !strings.Contains(d.TLD, ".") {
+		return d, err
+	}
+
+	isTLD, err := c.TLDLookup(ctx)
+	if err != nil {
+		return Domain{}, fmt.Errorf("unable to look up the TLD of domain: %s. Err: %w", domain, err)
+	}
+
+	registered, subdomain, err := SplitName(domain, isTLD)
+	if err != nil {
+		return Domain{}, err
+	}
+
+	if subdomain != "" {
+		return Domain{}, fmt.Errorf("domain: %s is a subdomain of %s. Use %s as the domain and put %s in the host names", domain, registered, registered, subdomain)
+	}
+
+	return registered, nil
+}
+
+// TLDLookup returns a function reporting whether a name is a TLD namecheap
+// supports, fetching the TLDs if they haven't been yet. The TLDs are cached
+// by the client so they're fetched once however they're looked up.
+func (c *Client) TLDLookup(ctx context.Context) (func(string) bool, error) {
+	c.tldMu.Lock()
+	names := c.tldNames
+	c.tldMu.Unlock()
+
+	if names == nil {
+		if _, err := c.GetTLDs(ctx); err != nil {
+			return nil, err
+		}
+
+		c.tldMu.Lock()
+		names = c.tldNames
+		c.tldMu.Unlock()
+	}
+
+	return func(tld string) bool { return names[tld] }, nil
+}
@@ -0,0 +1,79 @@
+package testing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libdns/libdns"
+	"github.com/libdns/namecheap"
+)
+
+// newProvider returns a provider for the account given by the flags. The
+// test is skipped when no credentials are given.
+func newProvider(t *testing.T) *namecheap.Provider {
+	t.Helper()
+
+	if *apiKey == "" || *apiUser == "" || *domain == "" {
+		t.Skip("Skipping integration test. Pass -api-key, -username and -domain to run it.")
+	}
+
+	return &namecheap.Provider{
+		APIKey:      *apiKey,
+		User:        *apiUser,
+		APIEndpoint: *apiEndpoint,
+		ClientIP:    *clientIP,
+	}
+}
+
+// withScratchZone replaces the records of the test domain with records, runs
+// fn and then restores the records the domain had before. The records are
+// restored even if fn fails the test.
+func withScratchZone(t *testing.T, records []libdns.Record, fn func(p *namecheap.Provider, zone string)) {
+	t.Helper()
+
+	p := newProvider(t)
+	zone := *domain
+
+	var original []libdns.Record
+	err := p.WithZoneTransaction(context.TODO(), zone, func(existing []libdns.Record) ([]libdns.Record, error) {
+		original = existing
+		return records, nil
+	})
+	if err != nil {
+		t.Fatalf("Unable to set up the records of zone: %s. Err: %s", zone, err)
+	}
+
+	t.Cleanup(func() {
+		err := p.WithZoneTransaction(context.TODO(), zone, func([]libdns.Record) ([]libdns.Record, error) {
+			// The IDs of the original records are gone once they're replaced.
+			restored := make([]libdns.Record, len(original))
+			for i, record := range original {
+				record.ID = ""
+				restored[i] = record
+			}
+			return restored, nil
+		})
+		if err != nil {
+			t.Errorf("Unable to restore the records of zone: %s. Err: %s", zone, err)
+		}
+	})
+
+	fn(p, zone)
+}
+
+func TestScratchZone(t *testing.T) {
+	records := []libdns.Record{
+		{Type: "TXT", Name: "scratch", Value: "libdns-namecheap"},
+	}
+
+	withScratchZone(t, records, func(p *namecheap.Provider, zone string) {
+		got, err := p.GetRecords(context.TODO(), zone)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got) != 1 || got[0].Name != "scratch" || got[0].Value != "libdns-namecheap" {
+			t.Fatalf("Expected only the scratch record. Got: %#v", got)
+		}
+	})
+}
@@ -0,0 +1,95 @@
+// Package lego converts between the host records used by lego's namecheap
+// DNS provider and libdns records to ease migrating from lego.
+package lego
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/libdns/libdns"
+)
+
+// Record is a namecheap host record in the format used by lego.
+// See: https://github.com/go-acme/lego/tree/master/providers/dns/namecheap
+type Record struct {
+	Type    string `xml:",attr"`
+	Name    string `xml:",attr"`
+	Address string `xml:",attr"`
+	MXPref  string `xml:",attr"`
+	TTL     string `xml:",attr"`
+}
+
+// ToLibdns converts a lego record into a libdns record.
+func ToLibdns(r Record) (libdns.Record, error) {
+	record := libdns.Record{
+		Type:  r.Type,
+		Name:  r.Name,
+		Value: r.Address,
+	}
+
+	if r.TTL != "" {
+		ttl, err := strconv.Atoi(r.TTL)
+		if err != nil {
+			return libdns.Record{}, fmt.Errorf("invalid TTL: %s for record: %s. Err: %s", r.TTL, r.Name, err)
+		}
+		record.TTL = time.Duration(ttl) * time.Second
+	}
+
+	if usesMXPref(r.Type) && r.MXPref != "" {
+		priority, err := strconv.Atoi(r.MXPref)
+		if err != nil {
+			return libdns.Record{}, fmt.Errorf("invalid MXPref: %s for record: %s. Err: %s", r.MXPref, r.Name, err)
+		}
+		record.Priority = priority
+	}
+
+	return record, nil
+}
+
+// FromLibdns converts a libdns record into a lego record.
+func FromLibdns(r libdns.Record) Record {
+	record := Record{
+		Type:    r.Type,
+		Name:    r.Name,
+		Address: r.Value,
+	}
+
+	if r.TTL > 0 {
+		record.TTL = strconv.Itoa(int(r.TTL / time.Second))
+	}
+
+	if usesMXPref(r.Type) {
+		record.MXPref = strconv.Itoa(r.Priority)
+	}
+
+	return record
+}
+
+// usesMXPref reports whether records of recordType keep their priority in
+// MXPref. namecheap stores the priority of SRV records there too.
+func usesMXPref(recordType string) bool {
+	return recordType == "MX" || recordType == "SRV"
+}
+
+// RecordsToLibdns converts lego records into libdns records.
+func RecordsToLibdns(records []Record) ([]libdns.Record, error) {
+	var converted []libdns.Record
+	for _, r := range records {
+		record, err := ToLibdns(r)
+		if err != nil {
+			return nil, err
+		}
+		converted = append(converted, record)
+	}
+	return converted, nil
+}
+
+// RecordsFromLibdns converts libdns records into lego records.
+func RecordsFromLibdns(records []libdns.Record) []Record {
+	var converted []Record
+	for _, r := range records {
+		converted = append(converted, FromLibdns(r))
+	}
+	return converted
+}
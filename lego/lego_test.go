@@ -0,0 +1,52 @@
+package lego_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/libdns/libdns"
+
+	"github.com/libdns/namecheap/lego"
+)
+
+var (
+	legoRecords = []lego.Record{
+		{Type: "A", Name: "@", Address: "1.2.3.4", TTL: "1800"},
+		{Type: "TXT", Name: "_acme-challenge", Address: "token", TTL: "60"},
+		{Type: "MX", Name: "@", Address: "mail.example.com", MXPref: "10", TTL: "1800"},
+		{Type: "CNAME", Name: "www", Address: "example.com"},
+		{Type: "SRV", Name: "_sip._tcp", Address: "5 5060 sip.example.com", MXPref: "20", TTL: "1800"},
+	}
+
+	libdnsRecords = []libdns.Record{
+		{Type: "A", Name: "@", Value: "1.2.3.4", TTL: time.Second * 1800},
+		{Type: "TXT", Name: "_acme-challenge", Value: "token", TTL: time.Second * 60},
+		{Type: "MX", Name: "@", Value: "mail.example.com", Priority: 10, TTL: time.Second * 1800},
+		{Type: "CNAME", Name: "www", Value: "example.com"},
+		{Type: "SRV", Name: "_sip._tcp", Value: "5 5060 sip.example.com", Priority: 20, TTL: time.Second * 1800},
+	}
+)
+
+func TestRecordsToLibdns(t *testing.T) {
+	records, err := lego.RecordsToLibdns(legoRecords)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if diff := cmp.Diff(libdnsRecords, records); diff != "" {
+		t.Fatalf("Converted records not equal to expected records. Diff: %s", diff)
+	}
+}
+
+func TestRecordsFromLibdns(t *testing.T) {
+	if diff := cmp.Diff(legoRecords, lego.RecordsFromLibdns(libdnsRecords)); diff != "" {
+		t.Fatalf("Converted records not equal to expected records. Diff: %s", diff)
+	}
+}
+
+func TestToLibdnsInvalidTTL(t *testing.T) {
+	if _, err := lego.ToLibdns(lego.Record{Type: "A", Name: "@", Address: "1.2.3.4", TTL: "forever"}); err == nil {
+		t.Fatal("Expected error but got nil")
+	}
+}
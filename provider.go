@@ -4,6 +4,14 @@ package namecheap
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,30 +20,488 @@ import (
 	"github.com/libdns/namecheap/internal/namecheap"
 )
 
-func parseIntoHostRecord(record libdns.Record) namecheap.HostRecord {
-	return namecheap.HostRecord{
+// TTLSeconds converts a record TTL into the whole number of seconds
+// namecheap stores. Fractions of a second are truncated.
+func TTLSeconds(ttl time.Duration) int {
+	return int(ttl / time.Second)
+}
+
+// TTLFromSeconds converts a TTL in seconds, as namecheap stores it,
+// into the duration used by libdns records.
+func TTLFromSeconds(seconds int) time.Duration {
+	return time.Duration(seconds) * time.Second
+}
+
+// The range of TTLs namecheap accepts in seconds.
+const (
+	minTTL = 60
+	maxTTL = 60000
+)
+
+// defaultTTL is the TTL in seconds namecheap uses for hosts without one.
+const defaultTTL = 1800
+
+// standardTTLs are the TTLs in seconds namecheap's dashboard offers. Other
+// TTLs are accepted by the API but may show up rounded in the dashboard.
+var standardTTLs = map[int]bool{
+	60:   true,
+	300:  true,
+	1200: true,
+	1800: true,
+	3600: true,
+}
+
+// clampTTL limits seconds to the range of TTLs namecheap accepts.
+// Zero is kept as is so namecheap uses its default TTL.
+func clampTTL(seconds int) uint16 {
+	switch {
+	case seconds <= 0:
+		return 0
+	case seconds < minTTL:
+		return minTTL
+	case seconds > maxTTL:
+		return maxTTL
+	default:
+		return uint16(seconds)
+	}
+}
+
+// normalizeCAAValue formats a CAA record value as namecheap expects it:
+// flags tag "value" e.g. 0 issue "letsencrypt.org". The value is quoted
+// exactly once whether or not it was quoted to begin with. Values that
+// don't have all three parts are returned unchanged.
+func normalizeCAAValue(value string) string {
+	parts := strings.SplitN(strings.TrimSpace(value), " ", 3)
+	if len(parts) != 3 {
+		return value
+	}
+
+	flags, tag := parts[0], parts[1]
+	caaValue := strings.Trim(strings.TrimSpace(parts[2]), `"`)
+
+	return fmt.Sprintf(`%s %s "%s"`, flags, tag, caaValue)
+}
+
+// splitMXValue splits an MX value in its zone file form e.g. "10 mail.example.com."
+// into its preference and target. ok is false if value is not in that form.
+func splitMXValue(value string) (preference int, target string, ok bool) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return 0, "", false
+	}
+
+	preference, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, "", false
+	}
+
+	return preference, fields[1], true
+}
+
+// splitSRVValue splits an SRV value of the form "weight port target" into its
+// parts. Values in zone file form "priority weight port target" are also
+// accepted in which case hasPriority is true. ok is false if value is in
+// neither form.
+func splitSRVValue(value string) (priority int, hasPriority bool, weightAndPort string, target string, ok bool) {
+	fields := strings.Fields(value)
+	if len(fields) == 4 {
+		p, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return 0, false, "", "", false
+		}
+		priority, hasPriority = p, true
+		fields = fields[1:]
+	}
+
+	if len(fields) != 3 {
+		return 0, false, "", "", false
+	}
+
+	for _, field := range fields[:2] {
+		if _, err := strconv.Atoi(field); err != nil {
+			return 0, false, "", "", false
+		}
+	}
+
+	return priority, hasPriority, fields[0] + " " + fields[1], fields[2], true
+}
+
+// Record types whose value is a hostname. namecheap stores hostnames without
+// the trailing dot while libdns expects them to be fully qualified.
+var hostnameValueTypes = map[namecheap.RecordType]bool{
+	namecheap.ALIAS: true,
+	namecheap.CNAME: true,
+	namecheap.MX:    true,
+	namecheap.NS:    true,
+}
+
+// Record types that libdns records can represent. Hosts of other types,
+// such as URL redirects, are kept as namecheap returned them when the
+// zone is rewritten.
+var modeledTypes = map[namecheap.RecordType]bool{
+	namecheap.A:     true,
+	namecheap.AAAA:  true,
+	namecheap.ALIAS: true,
+	namecheap.CAA:   true,
+	namecheap.CNAME: true,
+	namecheap.MX:    true,
+	namecheap.NS:    true,
+	namecheap.SRV:   true,
+	namecheap.TXT:   true,
+}
+
+// toNamecheapHostname formats a hostname the way namecheap stores it.
+func toNamecheapHostname(hostname string) string {
+	// The root on its own e.g. an SRV target of "." has to be kept.
+	if hostname == "." {
+		return hostname
+	}
+	return strings.TrimSuffix(hostname, ".")
+}
+
+// fromNamecheapHostname formats a hostname stored by namecheap as a fully qualified name.
+func fromNamecheapHostname(hostname string) string {
+	if hostname == "" || strings.HasSuffix(hostname, ".") {
+		return hostname
+	}
+	return hostname + "."
+}
+
+// relativeHostName returns name relative to zone the way namecheap names
+// hosts. name may be relative or fully qualified. The apex is returned as @.
+// Every name written to namecheap or compared with a host's name goes
+// through it so e.g. www, www. and www.example.com. are the same name.
+func relativeHostName(name, zone string) string {
+	name = strings.ToLower(name)
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+
+	if strings.HasSuffix(name, ".") {
+		name = strings.TrimSuffix(name, ".")
+		if name == zone {
+			name = ""
+		} else {
+			name = strings.TrimSuffix(name, "."+zone)
+		}
+	}
+
+	if name == "" {
+		return "@"
+	}
+
+	return name
+}
+
+// normalizeAddress checks that the value of an A or AAAA record is an IP
+// address of the matching family and formats it consistently. IPv4-mapped
+// IPv6 addresses such as ::ffff:1.2.3.4 are IPv4 addresses written as 1.2.3.4
+// so they are valid A records but not AAAA records.
+func normalizeAddress(recordType namecheap.RecordType, value string) (string, error) {
+	ip, err := netip.ParseAddr(value)
+	if err != nil {
+		return "", fmt.Errorf("value: %s of %s record is not an IP address", value, recordType)
+	}
+	ip = ip.Unmap()
+
+	if recordType == namecheap.A && !ip.Is4() {
+		return "", fmt.Errorf("value: %s of A record is not an IPv4 address. Use an AAAA record for IPv6 addresses", value)
+	}
+
+	if recordType == namecheap.AAAA && !ip.Is6() {
+		return "", fmt.Errorf("value: %s of AAAA record is not an IPv6 address. Use an A record for IPv4 addresses", value)
+	}
+
+	return ip.String(), nil
+}
+
+func parseIntoHostRecord(record libdns.Record) (namecheap.HostRecord, error) {
+	hostRecord := namecheap.HostRecord{
 		HostID:     record.ID,
-		RecordType: namecheap.RecordType(record.Type),
+		RecordType: namecheap.RecordType(strings.ToUpper(record.Type)),
 		Name:       record.Name,
-		TTL:        uint16(record.TTL.Seconds()),
+		TTL:        clampTTL(TTLSeconds(record.TTL)),
 		Address:    record.Value,
 	}
+
+	// namecheap refers to the apex of the zone as @.
+	if hostRecord.Name == "" {
+		hostRecord.Name = "@"
+	}
+
+	switch hostRecord.RecordType {
+	case namecheap.MX:
+		priority := record.Priority
+		// Generic records may carry the preference in the value instead.
+		if preference, target, ok := splitMXValue(record.Value); ok && priority == 0 {
+			priority = preference
+			hostRecord.Address = target
+		}
+		hostRecord.MXPref = strconv.Itoa(priority)
+	case namecheap.SRV:
+		priority := record.Priority
+		if p, hasPriority, weightAndPort, target, ok := splitSRVValue(record.Value); ok {
+			if hasPriority && priority == 0 {
+				priority = p
+			}
+			hostRecord.Address = weightAndPort + " " + toNamecheapHostname(target)
+		}
+		hostRecord.MXPref = strconv.Itoa(priority)
+	case namecheap.CAA:
+		hostRecord.Address = normalizeCAAValue(hostRecord.Address)
+	case namecheap.TXT:
+		// namecheap splits TXT values into 255 character strings itself when
+		// serving them, so long values such as DKIM keys are sent whole. Sending
+		// them as quoted strings would store the quotes as part of the value.
+	case namecheap.A, namecheap.AAAA:
+		address, err := normalizeAddress(hostRecord.RecordType, hostRecord.Address)
+		if err != nil {
+			return namecheap.HostRecord{}, err
+		}
+		hostRecord.Address = address
+	}
+
+	if hostnameValueTypes[hostRecord.RecordType] {
+		hostRecord.Address = toNamecheapHostname(hostRecord.Address)
+	}
+
+	return hostRecord, nil
 }
 
 func parseFromHostRecord(hostRecord namecheap.HostRecord) libdns.Record {
-	return libdns.Record{
+	record := libdns.Record{
 		ID:    hostRecord.HostID,
 		Type:  string(hostRecord.RecordType),
 		Name:  hostRecord.Name,
-		TTL:   time.Duration(hostRecord.TTL) * time.Second,
+		TTL:   TTLFromSeconds(int(hostRecord.TTL)),
 		Value: hostRecord.Address,
 	}
+
+	switch hostRecord.RecordType {
+	case namecheap.MX:
+		// namecheap returns an MXPref for every host but it only means something for MX records.
+		record.Priority, _ = strconv.Atoi(hostRecord.MXPref)
+	case namecheap.SRV:
+		record.Priority, _ = strconv.Atoi(hostRecord.MXPref)
+		if _, _, weightAndPort, target, ok := splitSRVValue(hostRecord.Address); ok {
+			record.Value = weightAndPort + " " + fromNamecheapHostname(target)
+		}
+	case namecheap.CAA:
+		record.Value = normalizeCAAValue(record.Value)
+	case namecheap.A, namecheap.AAAA:
+		// Leave a value that doesn't match the type as namecheap returned it
+		// so that reads don't fail.
+		if address, err := normalizeAddress(hostRecord.RecordType, record.Value); err == nil {
+			record.Value = address
+		}
+	}
+
+	if hostnameValueTypes[hostRecord.RecordType] {
+		record.Value = fromNamecheapHostname(record.Value)
+	}
+
+	return record
+}
+
+// InvalidRecord is a record that can't be stored by namecheap along
+// with the reason why.
+type InvalidRecord struct {
+	Record libdns.Record
+	Reason string
+}
+
+// InvalidRecordsError is returned by ValidateRecords and lists every
+// record that failed validation.
+type InvalidRecordsError []InvalidRecord
+
+func (e InvalidRecordsError) Error() string {
+	var reasons []string
+	for _, invalid := range e {
+		reasons = append(reasons, fmt.Sprintf("%s record %q: %s", invalid.Record.Type, invalid.Record.Name, invalid.Reason))
+	}
+	return fmt.Sprintf("%d invalid record(s): %s", len(e), strings.Join(reasons, "; "))
+}
+
+// ValidateRecords checks that namecheap is able to store each of the
+// records without making any requests. All the records that are invalid
+// are reported in the returned InvalidRecordsError.
+func ValidateRecords(records []libdns.Record) error {
+	var invalid InvalidRecordsError
+	for _, r := range records {
+		if reason := validateRecord(r); reason != "" {
+			invalid = append(invalid, InvalidRecord{Record: r, Reason: reason})
+		}
+	}
+
+	if len(invalid) > 0 {
+		return invalid
+	}
+
+	return nil
+}
+
+// validateRecord returns the reason the record is invalid or an empty
+// string if it's valid.
+func validateRecord(r libdns.Record) string {
+	if !namecheap.RecordType(strings.ToUpper(r.Type)).IsSupported() {
+		return fmt.Sprintf("record type %s is not supported by namecheap", r.Type)
+	}
+
+	if r.Value == "" {
+		return "value must not be empty"
+	}
+
+	if r.TTL < 0 {
+		return "TTL must not be negative"
+	}
+
+	if recordType := namecheap.RecordType(strings.ToUpper(r.Type)); recordType == namecheap.A || recordType == namecheap.AAAA {
+		if _, err := normalizeAddress(recordType, r.Value); err != nil {
+			return err.Error()
+		}
+	}
+
+	return ""
+}
+
+// APIError is returned when namecheap responds with errors. Use errors.As
+// to inspect the error Number e.g. 1011150 when the client IP isn't
+// whitelisted.
+type APIError = namecheap.APIError
+
+// ErrIPNotWhitelisted is matched by errors.Is when namecheap rejects a
+// request because the client IP isn't whitelisted. The error message
+// includes the IP that needs to be whitelisted.
+var ErrIPNotWhitelisted = namecheap.ErrIPNotWhitelisted
+
+// RequestTooLargeError is returned when the records of a zone don't fit in
+// the single request namecheap requires to write them. The error suggests how
+// many records the zone should be reduced to.
+type RequestTooLargeError = namecheap.RequestTooLargeError
+
+// TooManyHostsError is returned when a write would leave a zone with more
+// records than the provider's MaxHosts.
+type TooManyHostsError = namecheap.TooManyHostsError
+
+// ErrPremiumDNSRequired is matched by errors.Is when namecheap rejects a
+// change because it needs Premium DNS. The error message suggests upgrading.
+var ErrPremiumDNSRequired = namecheap.ErrPremiumDNSRequired
+
+// ErrRateLimited is matched by errors.Is when namecheap rejects a request
+// because the account exceeded one of its API quotas.
+var ErrRateLimited = namecheap.ErrRateLimited
+
+// RateLimitError is returned when namecheap rejects a request because the
+// account exceeded its per-minute, per-hour or per-day quota. Window reports
+// which one.
+type RateLimitError = namecheap.RateLimitError
+
+// Metrics receives counters and latencies of the requests made to namecheap
+// so they can be exported e.g. to Prometheus without this package depending
+// on a metrics library. Implementations must be safe for concurrent use.
+type Metrics = namecheap.Metrics
+
+// ErrReadOnly is returned by the methods that modify records when the
+// provider's ReadOnly is set.
+var ErrReadOnly = errors.New("provider is read-only")
+
+// ErrConcurrentModification is returned by the methods that modify records
+// when the provider's OptimisticLocking is set and the zone kept changing
+// while the records were being modified.
+var ErrConcurrentModification = namecheap.ErrConcurrentModification
+
+// ErrNotUsingNamecheapDNS is returned by the methods that modify records when
+// the zone doesn't use namecheap's nameservers and the provider's
+// RequireNamecheapDNS is set.
+var ErrNotUsingNamecheapDNS = namecheap.ErrNotUsingNamecheapDNS
+
+// ErrNothingDeleted is returned by DeleteRecords when none of the records
+// exist in the zone and the provider's ErrorOnNoopDelete is set.
+var ErrNothingDeleted = namecheap.ErrNothingDeleted
+
+// TooManyRecordsError is returned by GetRecords when the zone has more
+// records than the provider's MaxRecords.
+type TooManyRecordsError struct {
+	Zone  string
+	Limit int
+}
+
+func (e *TooManyRecordsError) Error() string {
+	return fmt.Sprintf("zone: %s has more than the maximum of %d records", e.Zone, e.Limit)
+}
+
+// Domain describes a domain registered in the namecheap account.
+type Domain struct {
+	// Name is the domain name e.g. example.com
+	Name string
+
+	// Created is the date the domain was registered.
+	Created time.Time
+
+	// Expires is the date the domain registration expires.
+	Expires time.Time
+
+	// IsExpired is true if the domain registration has expired.
+	IsExpired bool
+
+	// AutoRenew is true if the domain is set to renew automatically.
+	AutoRenew bool
+
+	// IsPremium is true if the domain was registered as a premium domain.
+	IsPremium bool
+
+	// WhoisGuard is the status of the WhoisGuard subscription e.g. ENABLED, NOTPRESENT
+	WhoisGuard string
+}
+
+func parseFromDomainInfo(info namecheap.DomainInfo) Domain {
+	return Domain{
+		Name:       info.Name,
+		Created:    info.Created,
+		Expires:    info.Expires,
+		IsExpired:  info.IsExpired,
+		AutoRenew:  info.AutoRenew,
+		IsPremium:  info.IsPremium,
+		WhoisGuard: info.WhoisGuard,
+	}
 }
 
+// Zone is a DNS zone managed by namecheap. It mirrors the libdns Zone type.
+type Zone struct {
+	// Name is the fully qualified name of the zone e.g. example.com.
+	Name string
+
+	// IsPremium is true if the domain was registered as a premium domain.
+	IsPremium bool
+}
+
+const (
+	// The endpoint of namecheap's production API.
+	productionEndpoint = "https://api.namecheap.com/xml.response"
+
+	// The endpoint of namecheap's sandbox API.
+	sandboxEndpoint = "https://api.sandbox.namecheap.com/xml.response"
+
+	// How often records are fetched while waiting for written records to be returned.
+	consistencyCheckInterval = time.Second
+
+	// The delay before the first retry if RetryDelay is not set.
+	defaultRetryDelay = time.Second
+)
+
 // Provider facilitates DNS record manipulation with namecheap.
 // The libdns methods that return updated structs do not have
 // their ID fields set since this information is not returned
 // by the namecheap API.
+//
+// Record names are relative to the zone. namecheap names the apex @ so the
+// records returned for the apex are named @. Records to write or match may
+// name the apex either @ or with an empty name, which are treated the same.
+//
+// Since namecheap replaces all the records of a zone with every write, the
+// methods that modify records hold a lock on the zone so concurrent changes
+// made through the same Provider can't undo each other. Changes to different
+// zones proceed in parallel. For the same reason a write can't be split into
+// several requests, so the whole zone has to fit in one. Writes over
+// MaxRequestSize or MaxHosts fail before anything is sent.
 type Provider struct {
 	// APIKey is your namecheap API key.
 	// See: https://www.namecheap.com/support/api/intro/
@@ -46,9 +512,16 @@ type Provider struct {
 	User string `json:"user,omitempty"`
 
 	// APIEndpoint to use. If testing, you can use the "sandbox" endpoint
-	// instead of the production one.
+	// instead of the production one. It must be an http or https URL.
+	// If this is not set, the production endpoint is used unless Sandbox
+	// is set.
 	APIEndpoint string `json:"api_endpoint,omitempty"`
 
+	// Sandbox uses namecheap's sandbox API. The sandbox has its own accounts
+	// so it needs separate credentials. It must not be set along with
+	// APIEndpoint.
+	Sandbox bool `json:"sandbox,omitempty"`
+
 	// ClientIP is the IP address of the requesting client.
 	// If this is not set, a discovery service will be
 	// used to determine the public ip of the machine.
@@ -56,26 +529,447 @@ type Provider struct {
 	// before using the API.
 	ClientIP string `json:"client_ip,omitempty"`
 
+	// DiscoveryEndpoints are the services asked for the public IP when
+	// ClientIP is not set. They are tried in order until one returns an IP.
+	// If this is not set, a default service is used.
+	DiscoveryEndpoints []string `json:"discovery_endpoints,omitempty"`
+
+	// FallbackClientIP is used as the client IP when ClientIP is not set and
+	// the public IP can't be discovered. The fallback is reported through
+	// WarningHook. If this is not set, failing to discover the IP is an error.
+	FallbackClientIP string `json:"fallback_client_ip,omitempty"`
+
+	// PreferIPv6Discovery prefers an IPv6 public IP over an IPv4 one when
+	// discovering the public IP, for example on IPv6 only networks.
+	PreferIPv6Discovery bool `json:"prefer_ipv6_discovery,omitempty"`
+
+	// IPDiscoveryRefresh is how often the public IP is discovered again when
+	// ClientIP is not set. If discovering it again fails, the previously
+	// discovered IP is used. If this is not set, the IP is discovered once.
+	IPDiscoveryRefresh time.Duration `json:"ip_discovery_refresh,omitempty"`
+
+	// ConsistencyTimeout is how long to wait after records are written
+	// for namecheap to return them when fetching records. namecheap doesn't
+	// always return written records immediately. Records namecheap stored
+	// with a different TTL than the one written are reported through
+	// WarningHook. If this is not set, writes don't wait.
+	ConsistencyTimeout time.Duration `json:"consistency_timeout,omitempty"`
+
+	// OperationBudget limits the total time each operation may take including
+	// all of the requests it makes. For example SetRecords fetches the existing
+	// records before setting them and both requests share the budget. If this
+	// is not set, operations are only limited by the context.
+	OperationBudget time.Duration `json:"operation_budget,omitempty"`
+
+	// RequestTimeout limits how long each request to namecheap may take,
+	// including the request to discover the public IP. An operation may make
+	// several requests and each of them gets the full timeout. The discovery,
+	// read and write timeouts take precedence over it. If none of them are
+	// set, discovering the IP may take 10 seconds, reading records 30 seconds
+	// and writing records 60 seconds.
+	RequestTimeout time.Duration `json:"request_timeout,omitempty"`
+
+	// DiscoveryTimeout limits how long each request to discover the public
+	// IP may take.
+	DiscoveryTimeout time.Duration `json:"discovery_timeout,omitempty"`
+
+	// ReadTimeout limits how long each request that reads records may take.
+	ReadTimeout time.Duration `json:"read_timeout,omitempty"`
+
+	// WriteTimeout limits how long each request that writes records may take.
+	WriteTimeout time.Duration `json:"write_timeout,omitempty"`
+
+	// CacheTTL is how long fetched records are cached for. Cached records are
+	// returned by GetRecords and used by the other methods instead of fetching
+	// the records again. The cache is cleared whenever records are written
+	// through this provider but changes made elsewhere won't be seen until the
+	// cache expires. If this is not set, records are not cached.
+	CacheTTL time.Duration `json:"cache_ttl,omitempty"`
+
+	// RecordTypes limits the records returned by GetRecords to these types
+	// e.g. []string{"A", "AAAA"}. Records of other types are skipped without
+	// being converted. If this is not set, records of all types are returned.
+	RecordTypes []string `json:"record_types,omitempty"`
+
+	// FQDNNames makes GetRecords and GetRecord return fully qualified names
+	// e.g. www.example.com. instead of www and the zone itself instead of @.
+	// The names are qualified with the zone as it was passed in. Following
+	// the libdns convention, names are relative to the zone by default.
+	FQDNNames bool `json:"fqdn_names,omitempty"`
+
+	// SkipExpiredZones excludes domains whose registration has expired
+	// from the zones returned by ListZones.
+	SkipExpiredZones bool `json:"skip_expired_zones,omitempty"`
+
+	// MaxAttempts is how many times a request to namecheap is attempted when
+	// it fails with a transient error such as an HTTP 5xx status. If this is
+	// not set, requests are not retried.
+	MaxAttempts int `json:"max_attempts,omitempty"`
+
+	// RetryDelay is the delay before the first retry. The delay doubles with
+	// each retry. Defaults to one second.
+	RetryDelay time.Duration `json:"retry_delay,omitempty"`
+
+	// MaxRecords is the most records GetRecords returns. If a zone has more
+	// records, GetRecords returns a TooManyRecordsError instead. If this is
+	// not set, there is no limit.
+	MaxRecords int `json:"max_records,omitempty"`
+
+	// PremiumDNSErrors are namecheap error numbers that mean a change needs
+	// Premium DNS. Errors with these numbers are reported as
+	// ErrPremiumDNSRequired in addition to errors whose message mentions
+	// Premium DNS.
+	PremiumDNSErrors []int `json:"premium_dns_errors,omitempty"`
+
+	// RateLimitErrors maps namecheap error numbers to the window of the quota
+	// they report being exceeded, which must be a minute, an hour or 24
+	// hours. Errors with these numbers are returned as a RateLimitError in
+	// addition to errors whose message mentions too many requests.
+	RateLimitErrors map[int]time.Duration `json:"rate_limit_errors,omitempty"`
+
+	// MaxRateLimitWait is the longest a rate limited request waits for its
+	// quota's window before it is retried when MaxAttempts allows retries.
+	// Requests limited for longer, such as by the per-hour or per-day quota,
+	// fail with a RateLimitError right away. Defaults to a minute.
+	MaxRateLimitWait time.Duration `json:"max_rate_limit_wait,omitempty"`
+
+	// MaxRequestSize is the largest request in bytes that is sent to write
+	// records. namecheap replaces all the records of a zone with each write so
+	// writes to zones too large for one request fail with a
+	// RequestTooLargeError, and writes close to the limit are reported through
+	// WarningHook. Defaults to a conservative estimate of namecheap's limit.
+	MaxRequestSize int `json:"max_request_size,omitempty"`
+
+	// MaxHosts is the most records a write may leave in a zone, including
+	// hosts libdns records can't represent. Writes over it fail with a
+	// TooManyHostsError stating the number of records without being sent.
+	// namecheap doesn't document a limit so if this is not set, only
+	// MaxRequestSize limits writes.
+	MaxHosts int `json:"max_hosts,omitempty"`
+
+	// DryRun skips writing records to namecheap. The methods that modify
+	// records still fetch the existing records, work out the changes and
+	// return as if the records were written. Use DryRunHook to preview the
+	// records that would have been written.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// DryRunHook is called in dry-run mode with all of the records the zone
+	// would have after each write. The records are exactly what would have
+	// been submitted to namecheap.
+	DryRunHook func(zone string, records []libdns.Record) `json:"-"`
+
+	// BeforeWrite is called with the records about to be written by
+	// AppendRecords, SetRecords, ReplaceZone, WithZoneTransaction and Batch,
+	// and with the desired records passed to DetectDrift, and the records it
+	// returns are used instead. Use it to apply custom
+	// normalization such as forcing TTLs. It is not called for the records
+	// passed to DeleteRecords since they only identify records to remove.
+	BeforeWrite func(records []libdns.Record) []libdns.Record `json:"-"`
+
+	// AfterRead is called with the records fetched from the zone by
+	// GetRecords, GetRecord, WithZoneTransaction and DeleteRecordsWhere before
+	// they are used, and the records it returns are used instead. Names are
+	// relative to the zone. DeleteRecordsWhere calls it with one record at a
+	// time so each result can be traced back to its host; records it drops
+	// are kept in the zone.
+	AfterRead func(records []libdns.Record) []libdns.Record `json:"-"`
+
+	// ReadOnly makes the methods that modify records, such as SetRecords,
+	// return ErrReadOnly without making any requests. Records can still be
+	// read. This guards against accidental changes e.g. in audit tooling.
+	ReadOnly bool `json:"read_only,omitempty"`
+
+	// EmailType is sent with every write to choose how namecheap handles
+	// email for the zone. It must be one of MX, MXE, FWD, OX, GMAIL or NONE.
+	// If this is not set, MX is sent when the zone has MX records and
+	// otherwise the zone's current email type is kept.
+	EmailType string `json:"email_type,omitempty"`
+
+	// CanonicalResults makes AppendRecords and SetRecords read the zone again
+	// after writing it and return the records as namecheap stored them, with
+	// their new IDs and any TTL namecheap adjusted, instead of the records
+	// that were passed in. Records namecheap doesn't return are left out.
+	// Each write costs an extra request. DeleteRecords always returns the
+	// removed records as namecheap stored them so it needs no extra request.
+	// It has no effect in dry-run mode since nothing is written.
+	CanonicalResults bool `json:"canonical_results,omitempty"`
+
+	// ErrorOnNoopDelete makes DeleteRecords return ErrNothingDeleted when
+	// none of the records exist in the zone so callers can tell a delete that
+	// had no effect from one that removed records.
+	// If this is not set, deleting records that don't exist is not an error.
+	ErrorOnNoopDelete bool `json:"error_on_noop_delete,omitempty"`
+
+	// RequireNamecheapDNS makes the methods that modify records fail with
+	// ErrNotUsingNamecheapDNS when the zone doesn't use namecheap's
+	// nameservers, since namecheap accepts records for such zones but
+	// doesn't serve them. If this is not set, writing to such a zone is
+	// reported through WarningHook and Logger instead.
+	RequireNamecheapDNS bool `json:"require_namecheap_dns,omitempty"`
+
+	// OptimisticLocking makes the provider check that the zone hasn't changed
+	// since it was read right before writing it, and make the change again if
+	// it has. namecheap replaces the whole zone on every write, so without this
+	// concurrent writers to the same zone can undo each other's changes. It
+	// covers every method that changes the zone based on its current records,
+	// including Batch, WithZoneTransaction, DeleteRecordsWhere and
+	// RenameRecord, but not ReplaceZone since it doesn't keep any of them.
+	// Each write costs an extra request.
+	OptimisticLocking bool `json:"optimistic_locking,omitempty"`
+
+	// WarningHook is called with a description of anything the provider had
+	// to adjust to satisfy namecheap, such as a TTL outside of the range
+	// namecheap accepts. If this is not set, the adjustments are silent.
+	WarningHook func(warning string) `json:"-"`
+
+	// Logger receives debug logs of every request made to namecheap with the
+	// command, domain, number of hosts submitted and the response status, as
+	// well as the warnings WarningHook receives. It is also warned about TTLs
+	// namecheap's dashboard doesn't offer, such as 90 seconds, since the
+	// dashboard may show them rounded. Warnings about a record carry its zone,
+	// name and TTL in seconds as attributes. The API key is never logged. If
+	// this is not set, nothing is logged.
+	Logger *slog.Logger `json:"-"`
+
+	// RequestHook is called with the command before every request made to
+	// namecheap, including retries, e.g. to count requests per command.
+	RequestHook func(ctx context.Context, command string) `json:"-"`
+
+	// ResponseHook is called after every request made to namecheap, including
+	// retries, with the command, the response status, how long the request
+	// took and the error it failed with if any, e.g. to record latency and
+	// error rates. status is empty if namecheap didn't respond.
+	ResponseHook func(ctx context.Context, command, status string, d time.Duration, err error) `json:"-"`
+
+	// Metrics receives a count of every request made to namecheap, including
+	// retries, along with its latency and, if it failed, the namecheap error
+	// number. If this is not set, nothing is recorded.
+	Metrics Metrics `json:"-"`
+
+	// RequestsPerMinute limits the rate of requests made to namecheap by this
+	// provider. Requests wait until they can be sent. namecheap allows 20
+	// requests per minute. If this is not set, requests are not limited.
+	RequestsPerMinute int `json:"requests_per_minute,omitempty"`
+
+	// RequestDump receives the exact parameters of every request that writes
+	// records, with the API key redacted, so they can be attached to bug
+	// reports. If this is not set, requests are not dumped.
+	RequestDump io.Writer `json:"-"`
+
+	// CharsetReader converts responses that aren't UTF-8 to UTF-8, for example
+	// when a proxy transcodes namecheap's responses. It is passed the charset
+	// the response declares. If this is not set, only UTF-8 is accepted.
+	CharsetReader func(charset string, input io.Reader) (io.Reader, error) `json:"-"`
+
 	mu sync.Mutex
+
+	// lazyClient is the client shared by all operations. It is created on
+	// first use and replaced when SetClientIPFromRequest changes the client IP.
+	lazyClient *lazyClient
+
+	// hostsCache is shared by all the clients created by the provider.
+	hostsCache *namecheap.HostsCache
+
+	// rateLimiter is shared by all the clients created by the provider.
+	rateLimiter *namecheap.RateLimiter
+
+	// zoneLocks serializes read-modify-write operations per zone.
+	zoneLocks map[string]*sync.Mutex
 }
 
-// getClient inititializes a new namecheap client.
+// lazyClient creates a client once no matter how many operations need it.
+// Creating it is tried again by the next operation if it fails, e.g. because
+// the public IP couldn't be discovered.
+type lazyClient struct {
+	mu     sync.Mutex
+	client *namecheap.Client
+}
+
+// newClient creates the clients used by providers. Tests replace it to
+// observe client construction.
+var newClient = namecheap.NewClient
+
+// getClient returns the client shared by all operations of the provider,
+// initializing it on first use. The provider's fields are read when the
+// client is initialized so changes made after the client was initialized
+// don't take effect. If initializing fails, it is tried again on the next
+// call.
 func (p *Provider) getClient() (*namecheap.Client, error) {
+	p.mu.Lock()
+	if p.lazyClient == nil {
+		p.lazyClient = &lazyClient{}
+	}
+	lazy := p.lazyClient
+	p.mu.Unlock()
+
+	lazy.mu.Lock()
+	defer lazy.mu.Unlock()
+
+	if lazy.client == nil {
+		client, err := p.buildClient()
+		if err != nil {
+			return nil, err
+		}
+		lazy.client = client
+	}
+
+	return lazy.client, nil
+}
+
+// buildClient initializes a new namecheap client from the provider's fields.
+func (p *Provider) buildClient() (*namecheap.Client, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	options := []namecheap.ClientOption{}
+	endpoint, err := p.endpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	// Zones such as www.example.com are subdomains that namecheap can't
+	// manage. Looking up the TLDs tells them apart from e.g. example.co.uk.
+	options := []namecheap.ClientOption{namecheap.WithTLDLookup(), namecheap.WithEndpoint(endpoint)}
 	if p.APIEndpoint != "" {
-		options = append(options, namecheap.WithEndpoint(p.APIEndpoint))
+		options = append(options, namecheap.WithEndpointValidation())
+	}
+
+	if p.ConsistencyTimeout > 0 {
+		options = append(options, namecheap.WithConsistencyCheck(p.ConsistencyTimeout, consistencyCheckInterval))
+	}
+
+	if p.CacheTTL > 0 {
+		if p.hostsCache == nil {
+			p.hostsCache = namecheap.NewHostsCache(p.CacheTTL)
+		}
+		options = append(options, namecheap.WithHostsCache(p.hostsCache))
+	}
+
+	if p.RequestsPerMinute > 0 {
+		if p.rateLimiter == nil {
+			p.rateLimiter = namecheap.NewRateLimiter(p.RequestsPerMinute)
+		}
+		options = append(options, namecheap.WithRateLimiter(p.rateLimiter))
+	}
+
+	if len(p.PremiumDNSErrors) > 0 {
+		options = append(options, namecheap.WithPremiumDNSErrors(p.PremiumDNSErrors...))
+	}
+
+	for number, window := range p.RateLimitErrors {
+		options = append(options, namecheap.WithRateLimitErrors(window, number))
+	}
+
+	if p.MaxRateLimitWait > 0 {
+		options = append(options, namecheap.WithMaxRateLimitWait(p.MaxRateLimitWait))
+	}
+
+	if p.EmailType != "" {
+		options = append(options, namecheap.WithEmailType(p.EmailType))
+	}
+
+	options = append(options, namecheap.WithWarnings(func(warning string) { p.warn(warning) }))
+	if p.MaxRequestSize > 0 {
+		options = append(options, namecheap.WithRequestSizeLimit(p.MaxRequestSize))
+	}
+
+	if p.MaxHosts > 0 {
+		options = append(options, namecheap.WithHostLimit(p.MaxHosts))
+	}
+
+	if p.DryRun {
+		var hook func(string, []namecheap.HostRecord)
+		if p.DryRunHook != nil {
+			hook = func(domain string, hosts []namecheap.HostRecord) {
+				records := make([]libdns.Record, 0, len(hosts))
+				for _, host := range hosts {
+					records = append(records, parseFromHostRecord(host))
+				}
+				p.DryRunHook(domain, records)
+			}
+		}
+		options = append(options, namecheap.WithDryRun(hook))
+	}
+
+	if p.ErrorOnNoopDelete {
+		options = append(options, namecheap.WithNoopDeleteError())
+	}
+
+	if p.OptimisticLocking {
+		options = append(options, namecheap.WithOptimisticLocking())
+	}
+
+	if p.RequireNamecheapDNS {
+		options = append(options, namecheap.WithRequireNamecheapDNS())
+	}
+
+	if p.RequestDump != nil {
+		options = append(options, namecheap.WithRequestDump(p.RequestDump))
+	}
+
+	if p.Logger != nil {
+		options = append(options, namecheap.WithLogger(p.Logger))
+	}
+
+	if p.RequestHook != nil {
+		options = append(options, namecheap.WithRequestHook(p.RequestHook))
+	}
+
+	if p.ResponseHook != nil {
+		options = append(options, namecheap.WithResponseHook(p.ResponseHook))
+	}
+
+	if p.Metrics != nil {
+		options = append(options, namecheap.WithMetrics(p.Metrics))
+	}
+
+	if p.CharsetReader != nil {
+		options = append(options, namecheap.WithXMLDecoder(p.CharsetReader))
+	}
+
+	if p.MaxAttempts > 1 {
+		retryDelay := p.RetryDelay
+		if retryDelay == 0 {
+			retryDelay = defaultRetryDelay
+		}
+		options = append(options, namecheap.WithRetry(p.MaxAttempts, retryDelay))
+	}
+
+	if p.OperationBudget > 0 {
+		options = append(options, namecheap.WithOperationBudget(p.OperationBudget))
+	}
+
+	if p.RequestTimeout > 0 {
+		options = append(options, namecheap.WithRequestTimeout(p.RequestTimeout))
+	}
+
+	if p.DiscoveryTimeout > 0 {
+		options = append(options, namecheap.WithDiscoveryTimeout(p.DiscoveryTimeout))
+	}
+
+	if p.ReadTimeout > 0 {
+		options = append(options, namecheap.WithReadTimeout(p.ReadTimeout))
+	}
+
+	if p.WriteTimeout > 0 {
+		options = append(options, namecheap.WithWriteTimeout(p.WriteTimeout))
 	}
 
 	if p.ClientIP == "" {
-		options = append(options, namecheap.AutoDiscoverPublicIP())
+		options = append(options, namecheap.AutoDiscoverPublicIP(p.DiscoveryEndpoints...))
+		if p.FallbackClientIP != "" {
+			options = append(options, namecheap.WithFallbackClientIP(p.FallbackClientIP))
+		}
+		if p.PreferIPv6Discovery {
+			options = append(options, namecheap.WithDiscoveryPreferIPv6())
+		}
+		if p.IPDiscoveryRefresh > 0 {
+			options = append(options, namecheap.WithIPDiscoveryRefresh(p.IPDiscoveryRefresh))
+		}
 	} else {
 		options = append(options, namecheap.WithClientIP(p.ClientIP))
 	}
 
-	client, err := namecheap.NewClient(p.APIKey, p.User, options...)
+	client, err := newClient(p.APIKey, p.User, options...)
 	if err != nil {
 		return nil, err
 	}
@@ -83,9 +977,173 @@ func (p *Provider) getClient() (*namecheap.Client, error) {
 	return client, nil
 }
 
+// endpoint returns the API endpoint requests are sent to. Sandbox and
+// APIEndpoint both choose the endpoint so setting both is an error.
+func (p *Provider) endpoint() (string, error) {
+	switch {
+	case p.APIEndpoint != "" && p.Sandbox:
+		return "", fmt.Errorf("APIEndpoint: %s and Sandbox must not both be set. Set Sandbox to use the sandbox endpoint or APIEndpoint to use another endpoint", p.APIEndpoint)
+	case p.APIEndpoint != "":
+		return p.APIEndpoint, nil
+	case p.Sandbox:
+		return sandboxEndpoint, nil
+	default:
+		return productionEndpoint, nil
+	}
+}
+
+// lockZone acquires the lock for the zone and returns a function
+// that releases it.
+func (p *Provider) lockZone(zone string) func() {
+	key := strings.ToLower(strings.TrimSuffix(zone, "."))
+
+	p.mu.Lock()
+	if p.zoneLocks == nil {
+		p.zoneLocks = make(map[string]*sync.Mutex)
+	}
+	lock, found := p.zoneLocks[key]
+	if !found {
+		lock = &sync.Mutex{}
+		p.zoneLocks[key] = lock
+	}
+	p.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// SetClientIPFromRequest sets ClientIP to the IP address of the client that
+// made r as reported by its X-Forwarded-For or X-Real-Ip headers. This is
+// useful when running behind a proxy that already knows the public IP. The
+// IP must be a public IPv4 address since that's all namecheap accepts.
+func (p *Provider) SetClientIPFromRequest(r *http.Request) error {
+	var ip string
+	if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+		// The left most address is the original client. The rest are proxies.
+		ip = strings.TrimSpace(strings.Split(forwardedFor, ",")[0])
+	} else {
+		ip = strings.TrimSpace(r.Header.Get("X-Real-Ip"))
+	}
+
+	if ip == "" {
+		return fmt.Errorf("request has no X-Forwarded-For or X-Real-Ip header to determine the client IP from")
+	}
+
+	parsedIP, err := netip.ParseAddr(ip)
+	// IPv4-mapped IPv6 addresses are IPv4 addresses.
+	parsedIP = parsedIP.Unmap()
+	if err != nil || !parsedIP.Is4() {
+		return fmt.Errorf("client IP: %s is not a valid IPv4 address", ip)
+	}
+
+	if !parsedIP.IsGlobalUnicast() || parsedIP.IsPrivate() {
+		return fmt.Errorf("client IP: %s is not a public IP address", ip)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ClientIP = parsedIP.String()
+
+	// The client was initialized with the previous IP.
+	p.lazyClient = nil
+
+	return nil
+}
+
+// warn reports a warning through the WarningHook and Logger if there are any.
+// The WarningHook only receives msg while the Logger also receives attrs.
+func (p *Provider) warn(msg string, attrs ...slog.Attr) {
+	if p.WarningHook != nil {
+		p.WarningHook(msg)
+	}
+	p.logWarning(msg, attrs...)
+}
+
+// logWarning logs a warning with attrs if there is a Logger. Unlike warn it
+// is for notices about records the provider didn't have to adjust.
+func (p *Provider) logWarning(msg string, attrs ...slog.Attr) {
+	if p.Logger != nil {
+		p.Logger.LogAttrs(context.Background(), slog.LevelWarn, msg, attrs...)
+	}
+}
+
+// beforeWrite passes records through the provider's BeforeWrite if it is set.
+func (p *Provider) beforeWrite(records []libdns.Record) []libdns.Record {
+	if p.BeforeWrite == nil {
+		return records
+	}
+	return p.BeforeWrite(records)
+}
+
+// afterRead passes records through the provider's AfterRead if it is set.
+func (p *Provider) afterRead(records []libdns.Record) []libdns.Record {
+	if p.AfterRead == nil {
+		return records
+	}
+	return p.AfterRead(records)
+}
+
+// toHostRecords converts records into host records to be written to zone,
+// warning about any TTL that had to be adjusted.
+func (p *Provider) toHostRecords(zone string, records []libdns.Record) ([]namecheap.HostRecord, error) {
+	var hostRecords []namecheap.HostRecord
+	for _, r := range records {
+		hostRecord, err := parseIntoHostRecord(r)
+		if err != nil {
+			return nil, err
+		}
+		hostRecord.Name = relativeHostName(r.Name, zone)
+		if seconds := TTLSeconds(r.TTL); seconds > 0 && int(hostRecord.TTL) != seconds {
+			p.warn(fmt.Sprintf("TTL of %s record %q adjusted from %d to %d seconds to be within namecheap's range of %d to %d seconds",
+				r.Type, r.Name, seconds, hostRecord.TTL, minTTL, maxTTL),
+				slog.String("zone", zone), slog.String("name", hostRecord.Name), slog.Int("ttl", seconds))
+		}
+		if r.TTL > 0 && !standardTTLs[int(hostRecord.TTL)] {
+			p.logWarning(fmt.Sprintf("TTL of %s record %q is %s which isn't one of the TTLs namecheap's dashboard offers so the dashboard may show it rounded",
+				r.Type, r.Name, r.TTL),
+				slog.String("zone", zone), slog.String("name", hostRecord.Name), slog.Int("ttl", int(hostRecord.TTL)))
+		}
+		hostRecords = append(hostRecords, hostRecord)
+	}
+	return hostRecords, nil
+}
+
+// toDeleteHostRecords converts records to remove into hosts to match. Records
+// with an ID only need the ID so the rest of them isn't checked. Records
+// without one are matched by name, type and value which are converted the way
+// they would be written. Unlike toHostRecords nothing is warned about since
+// the records aren't written.
+func toDeleteHostRecords(zone string, records []libdns.Record) ([]namecheap.HostRecord, error) {
+	var hostRecords []namecheap.HostRecord
+	for _, r := range records {
+		if r.ID != "" {
+			hostRecords = append(hostRecords, namecheap.HostRecord{HostID: r.ID})
+			continue
+		}
+
+		hostRecord, err := parseIntoHostRecord(r)
+		if err != nil {
+			return nil, err
+		}
+		hostRecord.Name = relativeHostName(r.Name, zone)
+		hostRecords = append(hostRecords, hostRecord)
+	}
+	return hostRecords, nil
+}
+
 // GetRecords lists all the records in the zone.
 // This method does return records with the ID field set.
 func (p *Provider) GetRecords(ctx context.Context, zone string) ([]libdns.Record, error) {
+	records, err := p.getRecords(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.withNameFormat(records, zone), nil
+}
+
+// getRecords lists the records in the zone with names relative to the zone.
+func (p *Provider) getRecords(ctx context.Context, zone string) ([]libdns.Record, error) {
 	client, err := p.getClient()
 	if err != nil {
 		return nil, err
@@ -96,21 +1154,127 @@ func (p *Provider) GetRecords(ctx context.Context, zone string) ([]libdns.Record
 		return nil, err
 	}
 
+	var allowedTypes map[namecheap.RecordType]bool
+	if len(p.RecordTypes) > 0 {
+		allowedTypes = make(map[namecheap.RecordType]bool)
+		for _, recordType := range p.RecordTypes {
+			allowedTypes[namecheap.RecordType(strings.ToUpper(recordType))] = true
+		}
+	}
+
 	var records []libdns.Record
 	for _, hr := range hostRecords {
+		if allowedTypes != nil && !allowedTypes[hr.RecordType] {
+			continue
+		}
+
+		if p.MaxRecords > 0 && len(records) == p.MaxRecords {
+			return nil, &TooManyRecordsError{Zone: zone, Limit: p.MaxRecords}
+		}
+
 		records = append(records, parseFromHostRecord(hr))
 	}
 
-	return records, nil
+	return p.afterRead(records), nil
+}
+
+// GetRecord returns the records in the zone with the name and type e.g. all
+// the A records of www for round-robin DNS. name may be relative to the zone
+// or fully qualified and the apex may be given as @ or an empty name. If
+// there are no matching records, an empty slice is returned.
+func (p *Provider) GetRecord(ctx context.Context, zone, name, recordType string) ([]libdns.Record, error) {
+	records, err := p.getRecords(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	name = relativeHostName(name, zone)
+	matching := []libdns.Record{}
+	for _, record := range records {
+		if relativeHostName(record.Name, zone) == name && strings.EqualFold(record.Type, recordType) {
+			matching = append(matching, record)
+		}
+	}
+
+	return p.withNameFormat(matching, zone), nil
+}
+
+// withNameFormat makes the names of the records fully qualified if the
+// provider's FQDNNames is set. The records are modified in place.
+func (p *Provider) withNameFormat(records []libdns.Record, zone string) []libdns.Record {
+	if !p.FQDNNames {
+		return records
+	}
+
+	for i := range records {
+		records[i].Name = libdns.AbsoluteName(records[i].Name, zone)
+	}
+
+	return records
+}
+
+// canonicalResults reports whether the methods that modify records should
+// read the zone again to return the records as namecheap stored them.
+func (p *Provider) canonicalResults() bool {
+	return p.CanonicalResults && !p.DryRun
+}
+
+// storedRecords reads the zone again and returns the hosts matching the
+// written hosts as namecheap stored them. Each stored host matches at most
+// one written host, with the same TTL if one was written. New hosts are
+// added after the existing ones, so the zone is searched from its end to
+// return them rather than existing duplicates.
+func (p *Provider) storedRecords(ctx context.Context, client *namecheap.Client, zone string, written []namecheap.HostRecord) ([]libdns.Record, error) {
+	hosts, err := client.GetHosts(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	unmatched := make(map[string][]namecheap.HostRecord)
+	for _, host := range written {
+		k := namecheap.HostKey(host)
+		unmatched[k] = append(unmatched[k], host)
+	}
+
+	var stored []namecheap.HostRecord
+	for i := len(hosts) - 1; i >= 0; i-- {
+		host := hosts[i]
+		k := namecheap.HostKey(host)
+		for j, w := range unmatched[k] {
+			if w.TTL == 0 || w.TTL == host.TTL {
+				unmatched[k] = append(unmatched[k][:j], unmatched[k][j+1:]...)
+				stored = append(stored, host)
+				break
+			}
+		}
+	}
+
+	// Return the hosts in the zone's order.
+	records := []libdns.Record{}
+	for i := len(stored) - 1; i >= 0; i-- {
+		records = append(records, parseFromHostRecord(stored[i]))
+	}
+
+	return p.withNameFormat(records, zone), nil
 }
 
 // AppendRecords adds records to the zone. It returns the records that were added.
 // Note that the records returned do NOT have their IDs set as the namecheap
 // API does not return this info.
 func (p *Provider) AppendRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
-	var hostRecords []namecheap.HostRecord
-	for _, r := range records {
-		hostRecords = append(hostRecords, parseIntoHostRecord(r))
+	if p.ReadOnly {
+		return nil, ErrReadOnly
+	}
+
+	// There's nothing to write so namecheap isn't asked at all.
+	if len(records) == 0 {
+		return []libdns.Record{}, nil
+	}
+
+	records = p.beforeWrite(records)
+	hostRecords, err := p.toHostRecords(zone, records)
+	if err != nil {
+		return nil, err
 	}
 
 	client, err := p.getClient()
@@ -118,11 +1282,18 @@ func (p *Provider) AppendRecords(ctx context.Context, zone string, records []lib
 		return nil, err
 	}
 
+	unlock := p.lockZone(zone)
+	defer unlock()
+
 	_, err = client.AddHosts(ctx, zone, hostRecords)
 	if err != nil {
 		return nil, err
 	}
 
+	if p.canonicalResults() {
+		return p.storedRecords(ctx, client, zone, hostRecords)
+	}
+
 	return records, nil
 }
 
@@ -130,9 +1301,18 @@ func (p *Provider) AppendRecords(ctx context.Context, zone string, records []lib
 // It returns the updated records. Note that this method may alter the IDs of existing records on the
 // server but may return records without their IDs set or with their old IDs set.
 func (p *Provider) SetRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
-	var hostRecords []namecheap.HostRecord
-	for _, r := range records {
-		hostRecords = append(hostRecords, parseIntoHostRecord(r))
+	if p.ReadOnly {
+		return nil, ErrReadOnly
+	}
+
+	if len(records) == 0 {
+		return []libdns.Record{}, nil
+	}
+
+	records = p.beforeWrite(records)
+	hostRecords, err := p.toHostRecords(zone, records)
+	if err != nil {
+		return nil, err
 	}
 
 	client, err := p.getClient()
@@ -140,21 +1320,39 @@ func (p *Provider) SetRecords(ctx context.Context, zone string, records []libdns
 		return nil, err
 	}
 
+	unlock := p.lockZone(zone)
+	defer unlock()
+
 	_, err = client.SetHosts(ctx, zone, hostRecords)
 	if err != nil {
 		return nil, err
 	}
 
+	if p.canonicalResults() {
+		return p.storedRecords(ctx, client, zone, hostRecords)
+	}
+
 	return records, nil
 }
 
-// DeleteRecords deletes the records from the zone. It returns the records that were deleted.
-// Note that the records returned do NOT have their IDs set as the namecheap
-// API does not return this info.
+// DeleteRecords deletes the records from the zone. Records with an ID, such as
+// those returned by GetRecords, are matched by ID alone so they can be deleted
+// even if other fields changed. A stale ID matches nothing. Records without an
+// ID are matched by name, type and value and remove every such record.
+// It returns only the records that existed and were removed, as namecheap
+// stored them, so the result is empty if none of the records existed.
 func (p *Provider) DeleteRecords(ctx context.Context, zone string, records []libdns.Record) ([]libdns.Record, error) {
-	var hostRecords []namecheap.HostRecord
-	for _, r := range records {
-		hostRecords = append(hostRecords, parseIntoHostRecord(r))
+	if p.ReadOnly {
+		return nil, ErrReadOnly
+	}
+
+	if len(records) == 0 {
+		return []libdns.Record{}, nil
+	}
+
+	hostRecords, err := toDeleteHostRecords(zone, records)
+	if err != nil {
+		return nil, err
 	}
 
 	client, err := p.getClient()
@@ -162,12 +1360,301 @@ func (p *Provider) DeleteRecords(ctx context.Context, zone string, records []lib
 		return nil, err
 	}
 
-	_, err = client.DeleteHosts(ctx, zone, hostRecords)
+	unlock := p.lockZone(zone)
+	defer unlock()
+
+	removedHosts, err := client.RemoveHosts(ctx, zone, hostRecords)
 	if err != nil {
 		return nil, err
 	}
 
-	return records, nil
+	// The removed hosts are already as namecheap stored them, so there's
+	// nothing to read again for CanonicalResults. Duplicates are told apart
+	// by their IDs here, which the zone no longer has after the write.
+	deleted := []libdns.Record{}
+	for _, host := range removedHosts {
+		deleted = append(deleted, parseFromHostRecord(host))
+	}
+
+	return p.withNameFormat(deleted, zone), nil
+}
+
+// WithZoneTransaction fetches the records in the zone and passes them to fn.
+// The records returned by fn replace all the records in the zone with a single
+// setHosts call. Records not returned by fn are removed from the zone. If fn
+// returns an error, the zone is left untouched. Other transactions on the same
+// zone are blocked until this one completes.
+//
+// Hosts that libdns records can't represent, such as URL redirects, are not
+// passed to fn and are written back exactly as they were. With
+// OptimisticLocking, fn is called again with the new records if the zone
+// changed before it could be written.
+func (p *Provider) WithZoneTransaction(ctx context.Context, zone string, fn func([]libdns.Record) ([]libdns.Record, error)) error {
+	if p.ReadOnly {
+		return ErrReadOnly
+	}
+
+	client, err := p.getClient()
+	if err != nil {
+		return err
+	}
+
+	unlock := p.lockZone(zone)
+	defer unlock()
+
+	_, err = client.ModifyHosts(ctx, zone, func(hostRecords []namecheap.HostRecord) ([]namecheap.HostRecord, bool, error) {
+		var records []libdns.Record
+		var unmodeled []namecheap.HostRecord
+		for _, hr := range hostRecords {
+			if !modeledTypes[hr.RecordType] {
+				unmodeled = append(unmodeled, hr)
+				continue
+			}
+			records = append(records, parseFromHostRecord(hr))
+		}
+
+		records, err := fn(p.afterRead(records))
+		if err != nil {
+			return nil, false, err
+		}
+
+		hosts, err := p.toHostRecords(zone, p.beforeWrite(records))
+		if err != nil {
+			return nil, false, err
+		}
+
+		return append(hosts, unmodeled...), true, nil
+	})
+	return err
+}
+
+// ReplaceZone replaces all the records in the zone with exactly records with a
+// single setHosts call, without merging them with the existing records.
+// Anything not in records is removed from the zone, including hosts that
+// libdns records can't represent such as URL redirects. Replacing a zone with
+// the same records again leaves it as it was, so it suits provisioning a
+// zone's complete desired state e.g. when migrating from another provider.
+//
+// namecheap resets a zone's email settings, such as email forwarding, when
+// they aren't sent with the write. So unless the provider's EmailType is set,
+// the zone is fetched first the first time it's written to learn them.
+func (p *Provider) ReplaceZone(ctx context.Context, zone string, records []libdns.Record) error {
+	if p.ReadOnly {
+		return ErrReadOnly
+	}
+
+	hosts, err := p.toHostRecords(zone, p.beforeWrite(records))
+	if err != nil {
+		return err
+	}
+
+	client, err := p.getClient()
+	if err != nil {
+		return err
+	}
+
+	unlock := p.lockZone(zone)
+	defer unlock()
+
+	if p.EmailType == "" && !client.HasEmailType(zone) {
+		if _, err := client.GetHosts(ctx, zone); err != nil {
+			return err
+		}
+	}
+
+	_, err = client.ReplaceHosts(ctx, zone, hosts)
+	return err
+}
+
+// DeleteRecordsWhere deletes all the records in the zone for which match
+// returns true with a single setHosts call, e.g. all the records pointing to
+// a retired IP. Records are passed through AfterRead before match sees them.
+// It returns the deleted records as match saw them. If no records match,
+// nothing is written. Hosts that libdns records can't represent, such as URL
+// redirects, are never passed to match and are kept.
+func (p *Provider) DeleteRecordsWhere(ctx context.Context, zone string, match func(libdns.Record) bool) ([]libdns.Record, error) {
+	if p.ReadOnly {
+		return nil, ErrReadOnly
+	}
+
+	client, err := p.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := p.lockZone(zone)
+	defer unlock()
+
+	var deleted []libdns.Record
+	_, err = client.ModifyHosts(ctx, zone, func(hosts []namecheap.HostRecord) ([]namecheap.HostRecord, bool, error) {
+		deleted = []libdns.Record{}
+		var kept []namecheap.HostRecord
+		for _, host := range hosts {
+			if modeledTypes[host.RecordType] {
+				if read := p.afterRead([]libdns.Record{parseFromHostRecord(host)}); len(read) == 1 && match(read[0]) {
+					deleted = append(deleted, read[0])
+					continue
+				}
+			}
+			kept = append(kept, host)
+		}
+		return kept, len(deleted) > 0, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return p.withNameFormat(deleted, zone), nil
+}
+
+// RenameRecord moves all the records of recordType named oldName to newName
+// with a single setHosts call. The names may be relative to the zone or fully
+// qualified and the apex may be given as @ or an empty name. It returns the
+// renamed records. If no records match, nothing is written.
+func (p *Provider) RenameRecord(ctx context.Context, zone, oldName, newName, recordType string) ([]libdns.Record, error) {
+	if p.ReadOnly {
+		return nil, ErrReadOnly
+	}
+
+	client, err := p.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := p.lockZone(zone)
+	defer unlock()
+
+	oldName = relativeHostName(oldName, zone)
+	newName = relativeHostName(newName, zone)
+	var renamed []libdns.Record
+	_, err = client.ModifyHosts(ctx, zone, func(hosts []namecheap.HostRecord) ([]namecheap.HostRecord, bool, error) {
+		renamed = []libdns.Record{}
+		for i, host := range hosts {
+			if relativeHostName(host.Name, zone) != oldName || !strings.EqualFold(string(host.RecordType), recordType) {
+				continue
+			}
+			hosts[i].Name = newName
+			renamed = append(renamed, parseFromHostRecord(hosts[i]))
+		}
+		return hosts, len(renamed) > 0 && oldName != newName, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return p.withNameFormat(renamed, zone), nil
+}
+
+// ListDomains lists the domains in the namecheap account along with
+// their registration details.
+func (p *Provider) ListDomains(ctx context.Context) ([]Domain, error) {
+	client, err := p.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	domainInfos, err := client.GetDomains(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var domains []Domain
+	for _, info := range domainInfos {
+		domains = append(domains, parseFromDomainInfo(info))
+	}
+
+	return domains, nil
+}
+
+// ListZones lists the zones of all the domains in the namecheap account.
+func (p *Provider) ListZones(ctx context.Context) ([]Zone, error) {
+	client, err := p.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	domainInfos, err := client.GetDomains(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var zones []Zone
+	for _, info := range domainInfos {
+		if p.SkipExpiredZones && info.IsExpired {
+			continue
+		}
+		zones = append(zones, Zone{Name: info.Name + ".", IsPremium: info.IsPremium})
+	}
+
+	return zones, nil
+}
+
+// GetNameservers returns the fully qualified names of the nameservers the
+// zone is delegated to. Use UsesNamecheapDNS to check that the zone is
+// served by namecheap before editing its records.
+func (p *Provider) GetNameservers(ctx context.Context, zone string) ([]string, error) {
+	client, err := p.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	domain, err := client.ResolveDomain(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+
+	nameservers, _, err := client.GetNameservers(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, ns := range nameservers {
+		nameservers[i] = fromNamecheapHostname(ns)
+	}
+
+	return nameservers, nil
+}
+
+// UsesNamecheapDNS reports whether the zone uses namecheap's nameservers. The
+// records managed by the provider are only served when it does.
+func (p *Provider) UsesNamecheapDNS(ctx context.Context, zone string) (bool, error) {
+	client, err := p.getClient()
+	if err != nil {
+		return false, err
+	}
+
+	domain, err := client.ResolveDomain(ctx, zone)
+	if err != nil {
+		return false, err
+	}
+
+	_, usingOurDNS, err := client.GetNameservers(ctx, domain)
+	if err != nil {
+		return false, err
+	}
+
+	return usingOurDNS, nil
+}
+
+// UseDefaultNameservers switches the zone's domain to namecheap's default
+// nameservers. The records managed by the provider are only served when the
+// domain uses namecheap's nameservers.
+func (p *Provider) UseDefaultNameservers(ctx context.Context, zone string) error {
+	if p.ReadOnly {
+		return ErrReadOnly
+	}
+
+	client, err := p.getClient()
+	if err != nil {
+		return err
+	}
+
+	domain, err := client.ResolveDomain(ctx, zone)
+	if err != nil {
+		return err
+	}
+
+	return client.SetDefaultNS(ctx, domain)
 }
 
 // Interface guards
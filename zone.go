@@ -0,0 +1,63 @@
+package namecheap
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/libdns/namecheap/internal/namecheap"
+)
+
+// ValidateZone checks that zone is a domain namecheap can manage the records
+// of, so that a zone namecheap doesn't support fails with a clear error
+// instead of an opaque one from setHosts. The zone's TLD must be one of the
+// TLDs namecheap supports and the zone must be registered directly under it.
+// The list of TLDs is fetched once and reused by later calls.
+func (p *Provider) ValidateZone(ctx context.Context, zone string) error {
+	domain, subdomain, err := p.splitName(ctx, zone)
+	if err != nil {
+		return err
+	}
+
+	if subdomain != "" {
+		return fmt.Errorf("zone: %s is a subdomain of %s. namecheap only manages the records of registered domains", zone, domain)
+	}
+
+	return nil
+}
+
+// SplitName splits a fully qualified name into the zone namecheap manages it
+// in and the name of the record relative to that zone. The longest TLD
+// namecheap supports is used so that api.example.co.uk. is split into the
+// zone example.co.uk. and the name api. The zone itself is named @.
+func (p *Provider) SplitName(ctx context.Context, name string) (zone, relativeName string, err error) {
+	domain, subdomain, err := p.splitName(ctx, name)
+	if err != nil {
+		return "", "", err
+	}
+
+	if subdomain == "" {
+		subdomain = "@"
+	}
+
+	return domain.String() + ".", subdomain, nil
+}
+
+// splitName splits name using the TLDs namecheap supports.
+func (p *Provider) splitName(ctx context.Context, name string) (namecheap.Domain, string, error) {
+	// Invalid names are rejected without fetching the TLDs.
+	if _, err := namecheap.ParseDomain(name); err != nil {
+		return namecheap.Domain{}, "", err
+	}
+
+	client, err := p.getClient()
+	if err != nil {
+		return namecheap.Domain{}, "", err
+	}
+
+	isTLD, err := client.TLDLookup(ctx)
+	if err != nil {
+		return namecheap.Domain{}, "", err
+	}
+
+	return namecheap.SplitName(name, isTLD)
+}